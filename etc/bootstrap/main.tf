@@ -0,0 +1,233 @@
+// Creates the prerequisites for cloud deployments in a project. This module is
+// applied by `platform bootstrap`, which keeps its state next to the deployment
+// states in the ops bucket once the bucket exists.
+terraform {
+  required_providers {
+    google = {
+      source  = "hashicorp/google"
+      version = ">= 6.47.0"
+    }
+  }
+}
+
+// Variables are read from terraform.tfvars.json, which the bootstrap command
+// generates from its flags.
+variable "OT_GCP_PROJECT" { type = string }
+variable "OT_GCP_REGION" { type = string }
+variable "OT_GCP_ZONE" { type = string }
+variable "OT_OPS_BUCKET" { type = string }
+variable "OT_GCP_SA_NAME" { type = string }
+variable "OT_GCP_NETWORK" { type = string }
+variable "OT_GCP_SUBNETWORK_RANGE" { type = string }
+variable "OT_GCP_SECRET_AI_TOKEN" { type = string }
+variable "OT_GCP_SECRET_ACCESS" { type = string }
+variable "OT_DOMAIN_NAME" { type = string }
+
+data "google_project" "project" {
+  project_id = var.OT_GCP_PROJECT
+}
+
+locals {
+  project = var.OT_GCP_PROJECT
+  member  = "serviceAccount:${google_service_account.devinstance.email}"
+  // The Cloud DNS zone is only created when the team owns a domain, otherwise
+  // deployments use the none DNS mode.
+  dns = var.OT_DOMAIN_NAME != ""
+}
+
+// APIS
+resource "google_project_service" "services" {
+  for_each = toset([
+    "compute.googleapis.com",
+    "dns.googleapis.com",
+    "iam.googleapis.com",
+    "iap.googleapis.com",
+    "secretmanager.googleapis.com",
+    "storage.googleapis.com",
+  ])
+  project            = local.project
+  service            = each.key
+  disable_on_destroy = false
+}
+
+// SERVICE ACCOUNT
+// The service account runs the deployment VMs, which delete themselves when
+// they expire, so it can only touch devinstance resources.
+resource "google_service_account" "devinstance" {
+  project      = local.project
+  account_id   = var.OT_GCP_SA_NAME
+  display_name = "Open Targets Platform standalone deployments"
+  depends_on   = [google_project_service.services]
+}
+
+resource "google_project_iam_member" "instance_admin" {
+  project = local.project
+  role    = "roles/compute.instanceAdmin.v1"
+  member  = local.member
+  condition {
+    title      = "Limited to devinstance instances"
+    expression = "resource.name.startsWith(\"projects/${local.project}/zones/${var.OT_GCP_ZONE}/instances/devinstance-\")"
+  }
+}
+
+// Instance schedules are run by the compute service agent, which needs to start
+// and stop the deployment VMs.
+resource "google_project_iam_member" "schedule_instance_admin" {
+  project = local.project
+  role    = "roles/compute.instanceAdmin.v1"
+  member  = "serviceAccount:service-${data.google_project.project.number}@compute-system.iam.gserviceaccount.com"
+  condition {
+    title      = "Limited to devinstance instances"
+    expression = "resource.name.startsWith(\"projects/${local.project}/zones/${var.OT_GCP_ZONE}/instances/devinstance-\")"
+  }
+  depends_on = [google_project_service.services]
+}
+
+resource "google_project_iam_member" "storage_admin" {
+  project = local.project
+  role    = "roles/compute.storageAdmin"
+  member  = local.member
+  condition {
+    title      = "Limited to devinstance disks"
+    expression = "resource.name.startsWith(\"projects/${local.project}/zones/${var.OT_GCP_ZONE}/disks/devinstance-\")"
+  }
+}
+
+resource "google_project_iam_member" "viewer" {
+  project = local.project
+  role    = "roles/compute.viewer"
+  member  = local.member
+  condition {
+    title      = "Limited to operations viewing"
+    expression = "resource.name.startsWith(\"projects/${local.project}/zones/${var.OT_GCP_ZONE}/operations/operation-\")"
+  }
+}
+
+resource "google_project_iam_member" "secret_accessor" {
+  project = local.project
+  role    = "roles/secretmanager.secretAccessor"
+  member  = local.member
+  // The access secrets of basic and oauth2 deployments are matched by prefix,
+  // so each deployment can have one of its own, e.g. platform-access-dev.
+  condition {
+    title      = "Limited to openai token and access secrets"
+    expression = "resource.name.startsWith(\"projects/${data.google_project.project.number}/secrets/${var.OT_GCP_SECRET_AI_TOKEN}\") || resource.name.startsWith(\"projects/${data.google_project.project.number}/secrets/${var.OT_GCP_SECRET_ACCESS}\")"
+  }
+}
+
+resource "google_project_iam_member" "security_admin" {
+  project = local.project
+  role    = "roles/compute.securityAdmin"
+  member  = local.member
+  condition {
+    title      = "Limited to devinstance firewall rules"
+    expression = "resource.name.startsWith(\"projects/${local.project}/global/firewalls/devinstance-\")"
+  }
+}
+
+resource "google_project_iam_member" "network_admin" {
+  project = local.project
+  role    = "roles/compute.networkAdmin"
+  member  = local.member
+  condition {
+    title      = "Limited to devinstance network"
+    expression = "resource.name == \"projects/${local.project}/global/networks/${var.OT_GCP_NETWORK}\""
+  }
+}
+
+resource "google_project_iam_member" "dns_admin" {
+  count   = local.dns ? 1 : 0
+  project = local.project
+  role    = "roles/dns.admin"
+  member  = local.member
+}
+
+// NETWORK
+resource "google_compute_network" "devinstance" {
+  project                 = local.project
+  name                    = var.OT_GCP_NETWORK
+  auto_create_subnetworks = false
+  depends_on              = [google_project_service.services]
+}
+
+resource "google_compute_subnetwork" "devinstance" {
+  project       = local.project
+  name          = var.OT_GCP_NETWORK
+  region        = var.OT_GCP_REGION
+  network       = google_compute_network.devinstance.id
+  ip_cidr_range = var.OT_GCP_SUBNETWORK_RANGE
+}
+
+// The tool runs commands on the deployment VMs, e.g. to stop the platform while
+// snapshotting its data, over ssh tunnelled through IAP, which connects from
+// this range. It is not named devinstance-, so reap never deletes it.
+resource "google_compute_firewall" "iap_ssh" {
+  project       = local.project
+  name          = "allow-iap-ssh-${var.OT_GCP_NETWORK}"
+  network       = google_compute_network.devinstance.id
+  source_ranges = ["35.235.240.0/20"]
+  target_tags   = ["devinstance"]
+
+  allow {
+    protocol = "tcp"
+    ports    = ["22"]
+  }
+}
+
+// SECRETS
+// Only the secrets are created, Secret Manager does not take empty versions.
+// Their first version, with the real token or credentials, is added by hand.
+resource "google_secret_manager_secret" "ai_token" {
+  project   = local.project
+  secret_id = var.OT_GCP_SECRET_AI_TOKEN
+  replication {
+    auto {}
+  }
+  depends_on = [google_project_service.services]
+}
+
+// The htpasswd file of basic access, or the oauth2-proxy env file of oauth2
+// access. It is only read by deployments that require authentication.
+resource "google_secret_manager_secret" "access" {
+  project   = local.project
+  secret_id = var.OT_GCP_SECRET_ACCESS
+  replication {
+    auto {}
+  }
+  depends_on = [google_project_service.services]
+}
+
+// OPS BUCKET
+resource "google_storage_bucket" "ops" {
+  project                     = local.project
+  name                        = var.OT_OPS_BUCKET
+  location                    = var.OT_GCP_REGION
+  uniform_bucket_level_access = true
+  versioning {
+    enabled = true
+  }
+  depends_on = [google_project_service.services]
+}
+
+// DNS ZONE
+resource "google_dns_managed_zone" "devinstance" {
+  count      = local.dns ? 1 : 0
+  project    = local.project
+  name       = replace(var.OT_DOMAIN_NAME, ".", "-")
+  dns_name   = "${var.OT_DOMAIN_NAME}."
+  depends_on = [google_project_service.services]
+}
+
+// OUTPUTS
+output "service_account" {
+  value = google_service_account.devinstance.email
+}
+
+output "cloud_dns_zone" {
+  value = local.dns ? google_dns_managed_zone.devinstance[0].name : ""
+}
+
+// The domain must be delegated to these name servers by its registrar.
+output "name_servers" {
+  value = local.dns ? join(" ", google_dns_managed_zone.devinstance[0].name_servers) : ""
+}
@@ -9,14 +9,39 @@ while true; do
     # shellcheck source=/dev/null
     source /platform/config
     set +a
-    export OT_WEBAPP_API_URL="https://$TF_VAR_OT_SUBDOMAIN_NAME.$TF_VAR_OT_DOMAIN_NAME/api/v4/graphql"
-    export OT_WEBAPP_OPENAI_URL="https://$TF_VAR_OT_SUBDOMAIN_NAME.$TF_VAR_OT_DOMAIN_NAME"
+    OT_HOSTNAME=$(curl -sH "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/hostname)
+    export OT_WEBAPP_API_URL="https://$OT_HOSTNAME/api/v4/graphql"
+    export OT_WEBAPP_OPENAI_URL="https://$OT_HOSTNAME"
+    export OT_ROOT_URL="https://$OT_HOSTNAME"
+    if [ "$TF_VAR_OT_ACCESS_MODE" == "oauth2" ]; then
+      export COMPOSE_PROFILES=oauth2
+    else
+      unset COMPOSE_PROFILES
+    fi
+
+    # the access mode may have changed, so refresh nginx and its credentials
+    curl -sH "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/nginx-conf > /etc/nginx/sites-enabled/default
+    rm -f /etc/nginx/htpasswd /platform/oauth2-proxy.env
+    case "$TF_VAR_OT_ACCESS_MODE" in
+      basic)
+        gcloud secrets versions access latest --secret="$TF_VAR_OT_GCP_SECRET_ACCESS" > /etc/nginx/htpasswd
+        chown root:www-data /etc/nginx/htpasswd
+        chmod 640 /etc/nginx/htpasswd
+        ;;
+      oauth2)
+        gcloud secrets versions access latest --secret="$TF_VAR_OT_GCP_SECRET_ACCESS" > /platform/oauth2-proxy.env
+        chmod 600 /platform/oauth2-proxy.env
+        ;;
+    esac
+    nginx -s reload
 
     umount /platform/clickhouse
     umount /platform/opensearch
 
     mount /dev/disk/by-id/google-datavolume-ch /platform/clickhouse
     mount /dev/disk/by-id/google-datavolume-os /platform/opensearch
+    resize2fs /dev/disk/by-id/google-datavolume-ch
+    resize2fs /dev/disk/by-id/google-datavolume-os
     chown -R 1000:1000 /platform/clickhouse
     chown -R 1000:1000 /platform/opensearch
 
@@ -1,12 +1,64 @@
 #!/bin/bash
 
-# mount data disks
-mkdir -p /platform/clickhouse
-mkdir -p /platform/opensearch
-mount /dev/disk/by-id/google-datavolume-ch /platform/clickhouse
-mount /dev/disk/by-id/google-datavolume-os /platform/opensearch
-chown -R 1000:1000 /platform/clickhouse
-chown -R 1000:1000 /platform/opensearch
+# this script runs on every boot, the installation only happens on the first one
+installed_marker=/platform/.installed
+
+mount_disks() {
+  mkdir -p /platform/clickhouse
+  mkdir -p /platform/opensearch
+  mount /dev/disk/by-id/google-datavolume-ch /platform/clickhouse
+  mount /dev/disk/by-id/google-datavolume-os /platform/opensearch
+  # grow the filesystems if the disks are bigger than their snapshots
+  resize2fs /dev/disk/by-id/google-datavolume-ch
+  resize2fs /dev/disk/by-id/google-datavolume-os
+  chown -R 1000:1000 /platform/clickhouse
+  chown -R 1000:1000 /platform/opensearch
+}
+
+load_config() {
+  set -a
+  # shellcheck source=/dev/null
+  source /platform/config
+  set +a
+  # the hostname depends on the dns mode, terraform works it out for us
+  OT_HOSTNAME=$(curl -sH "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/hostname)
+  # prepare frontend env vars
+  export OT_WEBAPP_API_URL="https://$OT_HOSTNAME/api/v4/graphql"
+  export OT_WEBAPP_OPENAI_URL="https://$OT_HOSTNAME"
+  export OT_ROOT_URL="https://$OT_HOSTNAME"
+  # the oauth2 proxy container only runs when it is the access mode
+  if [ "$TF_VAR_OT_ACCESS_MODE" == "oauth2" ]; then
+    export COMPOSE_PROFILES=oauth2
+  else
+    unset COMPOSE_PROFILES
+  fi
+}
+
+load_access_credentials() {
+  rm -f /etc/nginx/htpasswd /platform/oauth2-proxy.env
+  case "$TF_VAR_OT_ACCESS_MODE" in
+    basic)
+      gcloud secrets versions access latest --secret="$TF_VAR_OT_GCP_SECRET_ACCESS" > /etc/nginx/htpasswd
+      chown root:www-data /etc/nginx/htpasswd
+      chmod 640 /etc/nginx/htpasswd
+      ;;
+    oauth2)
+      gcloud secrets versions access latest --secret="$TF_VAR_OT_GCP_SECRET_ACCESS" > /platform/oauth2-proxy.env
+      chmod 600 /platform/oauth2-proxy.env
+      ;;
+  esac
+}
+
+mount_disks
+
+# on restarts (e.g. after a spot vm is preempted and started again) everything
+# is already in place, and nginx and the config watcher start on their own
+if [ -f "$installed_marker" ]; then
+  load_config
+  cd /platform || exit 1
+  docker compose -f compose.yaml up --quiet-build --quiet-pull -d
+  exit 0
+fi
 
 # install dependencies
 apt-get purge -y man-db
@@ -31,17 +83,12 @@ curl -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadat
 curl -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/cleanup > /platform/cleanup.sh
 curl -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/config-watcher-script > /platform/config-watcher.sh
 curl -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/config-watcher-service > /etc/systemd/system/config-watcher.service
-set -a
-# shellcheck source=/dev/null
-source /platform/config
-set +a
-# prepare frontend env vars
-export OT_WEBAPP_API_URL="https://$TF_VAR_OT_SUBDOMAIN_NAME.$TF_VAR_OT_DOMAIN_NAME/api/v4/graphql"
-export OT_WEBAPP_OPENAI_URL="https://$TF_VAR_OT_SUBDOMAIN_NAME.$TF_VAR_OT_DOMAIN_NAME"
+load_config
 
 # prepare secrets
 gcloud secrets versions access latest --secret="$TF_VAR_OT_GCP_SECRET_AI_TOKEN" > /platform/openai_token
 chmod 600 /platform/openai_token
+load_access_credentials
 
 # schedule cleanup script
 chmod +x /platform/cleanup.sh
@@ -50,18 +97,37 @@ if [ "$TF_VAR_OT_DAYS_TO_LIVE" -ne 0 ]; then
 fi
 
 # prepare cert
-certbot certonly \
-  --dns-google \
-  --non-interactive \
-  --agree-tos \
-  --email "admin@$TF_VAR_OT_DOMAIN_NAME" \
-  --domains "$TF_VAR_OT_SUBDOMAIN_NAME.$TF_VAR_OT_DOMAIN_NAME"
-
-# restart nginx
-nginx -s reload
+if [ "${TF_VAR_OT_DNS_MODE:-clouddns}" == "clouddns" ]; then
+  certbot certonly \
+    --dns-google \
+    --non-interactive \
+    --agree-tos \
+    --email "admin@$TF_VAR_OT_DOMAIN_NAME" \
+    --domains "$OT_HOSTNAME"
+  nginx -s reload
+else
+  # without cloud dns the certificate is validated over http, which only works
+  # once the hostname resolves to this instance (records created by hand in an
+  # external dns may take a while)
+  external_ip=$(curl -sH "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip)
+  until [ "$(getent hosts "$OT_HOSTNAME" | awk '{ print $1 }')" == "$external_ip" ]; do
+    echo "waiting for $OT_HOSTNAME to resolve to $external_ip"
+    sleep 30
+  done
+  # nginx holds port 80, certbot stops it while validating and on renewals
+  certbot certonly \
+    --standalone \
+    --pre-hook "systemctl stop nginx" \
+    --post-hook "systemctl start nginx" \
+    --non-interactive \
+    --agree-tos \
+    --register-unsafely-without-email \
+    --domains "$OT_HOSTNAME"
+fi
 
 # run platform and the config watcher
 cd /platform || exit 1
 docker compose -f compose.yaml up --quiet-build --quiet-pull -d
 chmod +x /platform/config-watcher.sh
 systemctl enable --now config-watcher
+touch "$installed_marker"
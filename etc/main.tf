@@ -8,31 +8,107 @@ terraform {
   backend "gcs" {}
 }
 
+// Variables are read from terraform.tfvars.json, which the deployment tool
+// generates from the TF_VAR_ settings in the config.
 variable "OT_SNAPSHOT_CH" { type = string }
 variable "OT_SNAPSHOT_OS" { type = string }
 variable "OT_DOMAIN_NAME" { type = string }
 variable "OT_SUBDOMAIN_NAME" { type = string }
+variable "OT_DNS_MODE" { type = string }
+variable "OT_DAYS_TO_LIVE" { type = string }
+variable "OT_MACHINE_TYPE" { type = string }
+variable "OT_PROVISIONING_MODEL" { type = string }
+variable "OT_SCHEDULE" { type = string }
+variable "OT_BOOT_IMAGE" { type = string }
+variable "OT_DATA_DISK_TYPE" { type = string }
+variable "OT_DATA_DISK_EXTRA_SIZE" { type = string }
 variable "OT_GCP_PROJECT" { type = string }
+variable "OT_GCP_REGION" { type = string }
 variable "OT_GCP_ZONE" { type = string }
+variable "OT_GCP_SECRET_AI_TOKEN" { type = string }
 variable "OT_GCP_CLOUD_DNS_ZONE" { type = string }
 variable "OT_GCP_NETWORK" { type = string }
 variable "OT_GCP_SA" { type = string }
+variable "OT_ALLOWED_SOURCE_RANGES" { type = string }
+variable "OT_ACCESS_MODE" { type = string }
+variable "OT_GCP_SECRET_ACCESS" { type = string }
+// A hash of the ops URI the deployment is stored in, set by the tool. Resources
+// carry it so `platform reap` only deletes those of its own ops URI.
+variable "OT_OPS_LABEL" { type = string }
 
 data "external" "whoami" {
   program = ["sh", "-c", "echo '{\"username\":\"'$(whoami)'\"}'"]
 }
 
+data "google_compute_snapshot" "clickhouse" {
+  name    = var.OT_SNAPSHOT_CH
+  project = var.OT_GCP_PROJECT
+}
+
+data "google_compute_snapshot" "opensearch" {
+  name    = var.OT_SNAPSHOT_OS
+  project = var.OT_GCP_PROJECT
+}
+
 locals {
   user = data.external.whoami.result.username
+  spot = var.OT_PROVISIONING_MODEL == "SPOT"
+  // Without a DNS zone the instance gets a static IP address, so that records
+  // created by hand stay valid across restarts. In none mode the hostname is
+  // derived from it, and sslip.io resolves it back to the address.
+  static_ip = var.OT_DNS_MODE != "clouddns"
+  hostname  = (
+    var.OT_DNS_MODE == "none"
+    ? "${var.OT_SUBDOMAIN_NAME}.${replace(google_compute_address.devinstance[0].address, ".", "-")}.sslip.io"
+    : "${var.OT_SUBDOMAIN_NAME}.${var.OT_DOMAIN_NAME}"
+  )
+  // A schedule such as `weekdays 08:00-20:00 Europe/London` is split into the
+  // days, start and stop times and time zone of an instance schedule policy.
+  schedule      = try(regex("^(daily|weekdays) (\\d{2}):(\\d{2})-(\\d{2}):(\\d{2}) (\\S+)$", var.OT_SCHEDULE), null)
+  schedule_days = try(local.schedule[0] == "weekdays" ? "1-5" : "*", "*")
+  // Firewall rules take no labels, so the ops label goes in their description.
+  ops_labels = {
+    "ops"        = var.OT_OPS_LABEL
+    "deployment" = var.OT_SUBDOMAIN_NAME
+  }
+  ops_description = "Open Targets Platform deployment ${var.OT_SUBDOMAIN_NAME}, ops label ${var.OT_OPS_LABEL}"
+}
+
+// ADDRESSES
+resource "google_compute_address" "devinstance" {
+  count   = local.static_ip ? 1 : 0
+  name    = "devinstance-${var.OT_SUBDOMAIN_NAME}"
+  project = var.OT_GCP_PROJECT
+  region  = var.OT_GCP_REGION
+  labels  = local.ops_labels
+}
+
+// SCHEDULES
+resource "google_compute_resource_policy" "schedule" {
+  count   = local.schedule != null ? 1 : 0
+  name    = "devinstance-schedule-${var.OT_SUBDOMAIN_NAME}"
+  project = var.OT_GCP_PROJECT
+  region  = var.OT_GCP_REGION
+  instance_schedule_policy {
+    vm_start_schedule {
+      schedule = "${tonumber(local.schedule[2])} ${tonumber(local.schedule[1])} * * ${local.schedule_days}"
+    }
+    vm_stop_schedule {
+      schedule = "${tonumber(local.schedule[4])} ${tonumber(local.schedule[3])} * * ${local.schedule_days}"
+    }
+    time_zone = local.schedule[5]
+  }
 }
 
 // FIREWALL RULES
 resource "google_compute_firewall" "devinstance_allow" {
   name          = "devinstance-allow-${var.OT_SUBDOMAIN_NAME}"
+  description   = local.ops_description
   project       = var.OT_GCP_PROJECT
   network       = var.OT_GCP_NETWORK
-  source_ranges = ["0.0.0.0/0"]
-  target_tags   = ["devinstance"]
+  source_ranges = [for r in split(",", var.OT_ALLOWED_SOURCE_RANGES) : trimspace(r)]
+  // Target only this deployment, other deployments may allow other ranges.
+  target_tags = ["devinstance-${var.OT_SUBDOMAIN_NAME}"]
   priority      = 65530
   allow {
     protocol = "tcp"
@@ -40,21 +116,42 @@ resource "google_compute_firewall" "devinstance_allow" {
   }
 }
 
+// Without Cloud DNS certificates are validated over HTTP, so letsencrypt must
+// reach port 80 whatever the allowed source ranges are.
+resource "google_compute_firewall" "devinstance_acme" {
+  count         = local.static_ip ? 1 : 0
+  name          = "devinstance-acme-${var.OT_SUBDOMAIN_NAME}"
+  description   = local.ops_description
+  project       = var.OT_GCP_PROJECT
+  network       = var.OT_GCP_NETWORK
+  source_ranges = ["0.0.0.0/0"]
+  target_tags   = ["devinstance-${var.OT_SUBDOMAIN_NAME}"]
+  priority      = 65530
+  allow {
+    protocol = "tcp"
+    ports    = ["80"]
+  }
+}
+
 // DISKS
 resource "google_compute_disk" "clickhouse_data_volume" {
   name     = "devinstance-datavolume-ch-${var.OT_SUBDOMAIN_NAME}"
   project  = var.OT_GCP_PROJECT
   zone     = var.OT_GCP_ZONE
-  type     = "pd-balanced"
+  type     = var.OT_DATA_DISK_TYPE
+  size     = data.google_compute_snapshot.clickhouse.disk_size_gb + tonumber(var.OT_DATA_DISK_EXTRA_SIZE)
   snapshot = "projects/${var.OT_GCP_PROJECT}/global/snapshots/${var.OT_SNAPSHOT_CH}"
+  labels   = local.ops_labels
 }
 
 resource "google_compute_disk" "opensearch_data_volume" {
   name     = "devinstance-datavolume-os-${var.OT_SUBDOMAIN_NAME}"
   project  = var.OT_GCP_PROJECT
   zone     = var.OT_GCP_ZONE
-  type     = "pd-balanced"
+  type     = var.OT_DATA_DISK_TYPE
+  size     = data.google_compute_snapshot.opensearch.disk_size_gb + tonumber(var.OT_DATA_DISK_EXTRA_SIZE)
   snapshot = "projects/${var.OT_GCP_PROJECT}/global/snapshots/${var.OT_SNAPSHOT_OS}"
+  labels   = local.ops_labels
 }
 
 // COMPUTE INSTANCES
@@ -62,10 +159,19 @@ resource "google_compute_instance" "dev_vm" {
   name         = "devinstance-${var.OT_SUBDOMAIN_NAME}"
   project      = var.OT_GCP_PROJECT
   zone         = var.OT_GCP_ZONE
-  machine_type = "n1-standard-4"
+  machine_type = var.OT_MACHINE_TYPE
+  scheduling {
+    provisioning_model  = var.OT_PROVISIONING_MODEL
+    preemptible         = local.spot
+    automatic_restart   = !local.spot
+    on_host_maintenance = local.spot ? "TERMINATE" : "MIGRATE"
+    // Preempted spot VMs are stopped rather than deleted, so they keep their
+    // disks and the platform runs again when they are started.
+    instance_termination_action = local.spot ? "STOP" : null
+  }
   boot_disk {
     initialize_params {
-      image = "debian-cloud/debian-12"
+      image = var.OT_BOOT_IMAGE
       type  = "pd-ssd"
       size  = "20"
     }
@@ -84,41 +190,48 @@ resource "google_compute_instance" "dev_vm" {
     network            = var.OT_GCP_NETWORK
     subnetwork         = var.OT_GCP_NETWORK
     subnetwork_project = var.OT_GCP_PROJECT
-    access_config {}
+    access_config {
+      nat_ip = local.static_ip ? google_compute_address.devinstance[0].address : null
+    }
   }
   service_account {
     email  = var.OT_GCP_SA
     scopes = ["cloud-platform"]
   }
-  labels = {
+  labels = merge(local.ops_labels, {
     "team"        = "opentargets"
     "product"     = "platform"
     "tool"        = "standalone"
     "environment" = "development"
     "created_by"  = "terraform"
     "author"      = local.user
-  }
-  tags = ["devinstance"]
+  })
+  tags = ["devinstance", "devinstance-${var.OT_SUBDOMAIN_NAME}"]
   metadata = {
     compose-file          = file("compose.yaml"),
     dockerfile-opensearch = file("Dockerfile-opensearch"),
     config                = file("config"),
+    hostname              = local.hostname,
     nginx-conf = templatefile("nginx.conf.tftpl", {
-      OT_DOMAIN_NAME    = var.OT_DOMAIN_NAME
-      OT_SUBDOMAIN_NAME = var.OT_SUBDOMAIN_NAME,
+      OT_HOSTNAME    = local.hostname,
+      OT_ACCESS_MODE = var.OT_ACCESS_MODE,
     }),
     cleanup = templatefile("cleanup.sh.tftpl", {
       OT_DOMAIN_NAME        = var.OT_DOMAIN_NAME,
       OT_SUBDOMAIN_NAME     = var.OT_SUBDOMAIN_NAME,
+      OT_DNS_MODE           = var.OT_DNS_MODE,
       OT_GCP_PROJECT        = var.OT_GCP_PROJECT,
       OT_GCP_ZONE           = var.OT_GCP_ZONE,
-      OT_GCP_NETWORK        = var.OT_GCP_NETWORK,
       OT_GCP_CLOUD_DNS_ZONE = var.OT_GCP_CLOUD_DNS_ZONE,
     }),
     config-watcher-script  = file("config-watcher.sh"),
     config-watcher-service = file("config-watcher.service"),
   }
   metadata_startup_script = file("google-startup-script.sh")
+  resource_policies       = local.schedule != null ? [google_compute_resource_policy.schedule[0].id] : []
+
+  // Changing the machine type requires stopping the instance.
+  allow_stopping_for_update = true
 
   lifecycle {
     ignore_changes = [
@@ -131,6 +244,7 @@ resource "google_compute_instance" "dev_vm" {
 
 // DNS RECORD SETS
 resource "google_dns_record_set" "main" {
+  count        = var.OT_DNS_MODE == "clouddns" ? 1 : 0
   name         = "${var.OT_SUBDOMAIN_NAME}.${var.OT_DOMAIN_NAME}."
   project      = var.OT_GCP_PROJECT
   managed_zone = var.OT_GCP_CLOUD_DNS_ZONE
@@ -141,5 +255,14 @@ resource "google_dns_record_set" "main" {
 
 // OUTPUTS
 output "instance_url" {
-  value = "https://${var.OT_SUBDOMAIN_NAME}.${var.OT_DOMAIN_NAME}"
+  value = "https://${local.hostname}"
+}
+
+// The record to create by hand when the DNS is managed externally.
+output "dns_record" {
+  value = (
+    var.OT_DNS_MODE == "external"
+    ? "${local.hostname}. 300 IN A ${google_compute_address.devinstance[0].address}"
+    : ""
+  )
 }
@@ -0,0 +1,55 @@
+#!/bin/bash
+
+# builds the data disks of a release for `platform snapshots create`. progress is
+# reported on the serial console, where the tool looks for the result once the
+# vm powers itself off.
+
+log() {
+  echo "snapshot-builder: $*" | tee /dev/ttyS0
+}
+
+fail() {
+  log "failed: $*"
+  poweroff
+  exit 1
+}
+
+metadata() {
+  curl -sfH "Metadata-Flavor: Google" "http://metadata.google.internal/computeMetadata/v1/instance/attributes/$1"
+}
+
+# the startup script runs on every boot, but the builder is only booted once
+release=$(metadata release) || fail "no release in metadata"
+release_url=$(metadata release-url) || fail "no release url in metadata"
+
+log "installing dependencies"
+apt-get update || fail "updating packages"
+apt-get install -y pigz || fail "installing pigz"
+
+for kind in ch os; do
+  case $kind in
+    ch) engine=clickhouse ;;
+    os) engine=opensearch ;;
+  esac
+  device=/dev/disk/by-id/google-datavolume-$kind
+  mountpoint=/build/$engine
+
+  log "formatting $engine disk"
+  mkfs.ext4 -F "$device" || fail "formatting $engine disk"
+  mkdir -p "$mountpoint"
+  mount "$device" "$mountpoint" || fail "mounting $engine disk"
+
+  # same archives and layout as local deployments
+  log "populating $engine disk from $release_url/$release/disk_images/$engine.tgz"
+  curl -fsSL "$release_url/$release/disk_images/$engine.tgz" | pigz -dc | tar -x -C "$mountpoint"
+  if [ "${PIPESTATUS[0]}" -ne 0 ] || [ "${PIPESTATUS[2]}" -ne 0 ]; then
+    fail "populating $engine disk"
+  fi
+  chown -R 1000:1000 "$mountpoint"
+
+  sync
+  umount "$mountpoint" || fail "unmounting $engine disk"
+done
+
+log "done"
+poweroff
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/housekeeping"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// RunBootstrap creates the cloud prerequisites for a project.
+func RunBootstrap(auto bool, b *housekeeping.BootstrapConfig) {
+	// 1. Fill in the settings derived from the project
+	if b.OpsURI == "" {
+		b.OpsURI = fmt.Sprintf("gs://%s-ops/terraform/devinstance", b.Project)
+	}
+	if b.DefaultsPath == "" {
+		b.DefaultsPath = fmt.Sprintf("./etc/defaults-cloud-%s", b.Project)
+	}
+
+	// 2. Validate the settings
+	var errs []error
+	tools.AppendIfErr(&errs, config.ValidateGCPProject(b.Project))
+	tools.AppendIfErr(&errs, config.ValidateGCPRegion(func() string { return b.Project })(b.Region))
+	tools.AppendIfErr(&errs, config.ValidateGCPZone(func() string { return b.Project })(b.Zone))
+	tools.AppendIfErr(&errs, config.ValidateGCPResource(b.ServiceAccount))
+	tools.AppendIfErr(&errs, config.ValidateGCPResource(b.Network))
+	tools.AppendIfErr(&errs, config.ValidateGCPResource(b.SecretAIToken))
+	tools.AppendIfErr(&errs, config.ValidateGCPResource(b.SecretAccess))
+	if b.DomainName != "" {
+		tools.AppendIfErr(&errs, config.ValidateDomainName(b.DomainName))
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("%v\n", err)
+		}
+		log.Fatal("bad bootstrap settings")
+	}
+
+	// 3. Show what will be created and, if interactive, request confirmation
+	log.Printf("bootstrapping project %s:\n", b.Project)
+	log.Printf("·  service account %s\n", b.ServiceAccount)
+	log.Printf("·  network and subnetwork %s in %s (%s)\n", b.Network, b.Region, b.SubnetworkRange)
+	log.Printf("·  secrets %s and %s\n", b.SecretAIToken, b.SecretAccess)
+	log.Printf("·  ops bucket for %s\n", b.OpsURI)
+	if b.DomainName != "" {
+		log.Printf("·  cloud dns zone for %s\n", b.DomainName)
+	}
+	if !auto {
+		var proceed bool
+		pf := config.ConfirmationForm(&proceed)
+		err := pf.Run()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if !proceed {
+			log.Fatal("exiting without bootstrapping")
+		}
+	}
+
+	// 4. Run terraform
+	var outputs map[string]string
+	var err error
+	action := func() {
+		outputs, err = housekeeping.Bootstrap(b, nil)
+	}
+	tools.RunWithSpinner("bootstrapping", action)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// 5. Show success message
+	log.Println("Bootstrap completed successfully!")
+	log.Printf("·  defaults written to %s, deploy with --config %s\n", b.DefaultsPath, b.DefaultsPath)
+	log.Printf("·  add your OpenAI API token to the %s secret before deploying: gcloud secrets versions add %s --project %s --data-file <token-file>\n", b.SecretAIToken, b.SecretAIToken, b.Project)
+	log.Printf("·  for basic or oauth2 access, add the htpasswd or oauth2-proxy env file to the %s secret, or to a secret named %s-<suffix>\n", b.SecretAccess, b.SecretAccess)
+	log.Println("·  data snapshots must be available in the project")
+	if ns := outputs["name_servers"]; ns != "" {
+		log.Printf("·  delegate %s to these name servers: %s\n", b.DomainName, ns)
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/housekeeping"
+)
+
+// RunClone deploys a copy of a cloud deployment under a new subdomain. With
+// withData, the clone starts from snapshots of the source data disks instead of
+// the release snapshots.
+func RunClone(source, subdomain string, withData, auto, skipPreflight, noEnv bool, opsURI string) {
+	// 1. Load the source config
+	c := loadDeploymentConfig(source, opsURI)
+	if !noEnv {
+		c.ReplaceFromEnv()
+	}
+
+	// 2. Rename, and check the new subdomain is free
+	if err := config.ValidateSubdomainName(subdomain); err != nil {
+		log.Fatalf("invalid subdomain name: %v\n", err)
+	}
+	sourceConfig := *c
+	c.SubdomainName.Value = subdomain
+	existing := findExisting(c)
+	if existing.Exists() {
+		log.Fatalf("deployment %s already exists in %s\n", subdomain, c.OpsURI.Value)
+	}
+
+	// 3. Validate the config as it will be deployed
+	if err := c.Validate(); err != nil {
+		log.Fatalf("bad configuration: %v\n", err)
+	}
+	if !skipPreflight {
+		preflightCloud(c)
+	}
+
+	// 4. Print the configuration to the console, and if interactive, request confirmation.
+	log.Printf("%s\n", config.Summary(c))
+	if withData {
+		log.Printf("the data disks of %s will be snapshotted for the clone\n", sourceConfig.SubdomainName.Value)
+	}
+	if !auto {
+		confirmCloud("exiting without cloning")
+	}
+
+	// 5. Snapshot the source data
+	if withData {
+		// The snapshots are labelled with the clone, so reap deletes them once
+		// it is destroyed. They cannot go sooner, as its config refers to them.
+		log.Printf("saving the data disks of %s\n", sourceConfig.SubdomainName.Value)
+		labels := housekeeping.DeploymentLabels(c.OpsURI.Value, subdomain)
+		names, err := housekeeping.SaveSnapshots(&sourceConfig, "clone-"+subdomain, labels, log.Writer())
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		c.SnapshotCH.Value = names[0]
+		c.SnapshotOS.Value = names[1]
+	}
+
+	// 6. Deploy, in the terraform workspace of the new subdomain
+	deployCloud(c, existing)
+}
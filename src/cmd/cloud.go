@@ -1,9 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"slices"
 	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/opentargets/platform-deployment-standalone/internal/config"
@@ -12,7 +18,7 @@ import (
 )
 
 // RunCloud runs the cloud deployment setup.
-func RunCloud(auto bool, configPath string) {
+func RunCloud(auto bool, configPath string, skipPreflight, adopt, noEnv bool) {
 	// 1. Load defaults
 	c, err := config.NewCloudDeploymentConfig(configPath)
 	if err != nil {
@@ -20,7 +26,9 @@ func RunCloud(auto bool, configPath string) {
 	}
 
 	// 2. Parse env vars
-	c.ReplaceFromEnv()
+	if !noEnv {
+		c.ReplaceFromEnv()
+	}
 
 	// 3. If non-interactive mode, validate the config and exit if there are errors.
 	// Otherwise, present the configuration form.
@@ -31,109 +39,345 @@ func RunCloud(auto bool, configPath string) {
 		}
 	} else {
 		cf := config.CloudDeploymentForm(c)
-		err = cf.Run()
+		err = config.RunForm(cf, c)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 	}
 
-	// 4. Print the configuration to the console, and if interactive, request confirmation.
-	log.Printf("%s\n", c.ToString())
-	if !auto {
-		var proceed bool
-		pf := config.ConfirmationForm(&proceed)
-		err = pf.Run()
-		if err != nil {
-			log.Fatal(err.Error())
-		}
-		if !proceed {
-			log.Fatal("exiting without deploying")
+	// 4. Make sure another deployment with the same name is not taken over. A
+	// deployment is updated by deploying its own config from the ops URI.
+	existing := findExisting(c)
+	ownConfig := configPath == fmt.Sprintf("%s/%s", c.OpsURI.Value, c.SubdomainName.Value)
+	if existing.Exists() && !ownConfig {
+		if !adopt {
+			log.Fatalf("deployment %s already exists in %s, choose another subdomain name, or use --adopt to take it over\n", c.SubdomainName.Value, c.OpsURI.Value)
 		}
+		log.Printf("deployment %s already exists, it will be taken over\n", c.SubdomainName.Value)
 	}
 
-	// 5. Prepare deployment directory
-	housekeeping.PrepareDeploymentDir(c)
-	housekeeping.WriteConfig(c)
+	// 5. Check permissions and quota before terraform fails halfway through.
+	if !skipPreflight {
+		preflightCloud(c)
+	}
 
-	// 6. Run deployment
+	// 6. Print the configuration to the console, and if interactive, request confirmation.
+	log.Printf("%s\n", config.Summary(c))
+	if !auto {
+		confirmCloud("exiting without deploying")
+	}
+
+	// 7. Deploy
+	deployCloud(c, existing)
+}
+
+// findExisting looks for what is already stored under the name of a deployment,
+// and exits if it cannot be checked.
+func findExisting(c *config.CloudDeploymentConfig) *housekeeping.ExistingDeployment {
+	var existing *housekeeping.ExistingDeployment
+	var err error
+	find := func() {
+		existing, err = housekeeping.FindExisting(c)
+	}
+	tools.RunWithSpinner(fmt.Sprintf("checking for existing deployment %s", c.SubdomainName.Value), find)
+	if err != nil {
+		log.Fatalf("error checking for existing deployment: %v\n", err)
+	}
+	return existing
+}
+
+// preflightCloud checks permissions and quota for a deployment, and exits if
+// they fall short.
+func preflightCloud(c *config.CloudDeploymentConfig) {
+	var err error
+	preflight := func() {
+		err = c.Preflight()
+	}
+	tools.RunWithSpinner("checking permissions and quota", preflight)
+	if err != nil {
+		log.Fatalf("preflight checks failed:\n%v\n", err)
+	}
+}
+
+// confirmCloud asks for confirmation of the configuration printed above, and
+// exits with message if it is not given.
+func confirmCloud(message string) {
+	var proceed bool
+	pf := config.ConfirmationForm(&proceed)
+	err := pf.Run()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if !proceed {
+		log.Fatal(message)
+	}
+}
+
+// deployCloud prepares the deployment directory, runs the deployment and
+// uploads its configuration to the ops URI. The deployment is locked meanwhile,
+// and it is not deployed if it changed since existing was found.
+func deployCloud(c *config.CloudDeploymentConfig, existing *housekeeping.ExistingDeployment) {
+	// 1. Lock the deployment. Fatal errors skip deferred calls, so it is
+	// unlocked before any of them.
+	unlock, err := housekeeping.Lock(c, "deploy")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	fatal := func(v ...any) {
+		unlock()
+		log.Fatal(v...)
+	}
+
+	// 2. Check nobody got to the deployment since it was checked
+	if current := findExisting(c); *current != *existing {
+		fatal(fmt.Sprintf("deployment %s was changed by someone else in the meantime, run the command again", c.SubdomainName.Value))
+	}
+
+	// 3. Prepare deployment directory
+	if err := housekeeping.PrepareDeploymentDir(c); err != nil {
+		fatal(err.Error())
+	}
+	if err := housekeeping.WriteConfig(c); err != nil {
+		fatal(err.Error())
+	}
+
+	// 4. Run deployment
+	var outputs map[string]string
 	action := func() {
-		housekeeping.DeployCloud(c)
+		outputs, err = housekeeping.DeployCloud(c, nil)
 	}
 	tools.RunWithSpinner("deploying", action)
+	if err != nil {
+		fatal(err.Error())
+	}
 
-	// 8. Upload the configuration file to GCS
-	err = housekeeping.UploadConfig(c)
+	// 5. Upload the configuration file to GCS
+	err = housekeeping.UploadConfig(c, outputs, existing.ConfigGeneration)
 	if err != nil {
 		log.Printf("error uploading configuration file to ops uri: %v\n", err)
 	}
+	unlock()
 
-	// 7. Show success message
+	// 6. Show success message
 	log.Println("Deployment completed successfully! Instance available at:")
-	log.Printf("·  https://%s.%s\n", c.SubdomainName.Value, c.DomainName.Value)
-	log.Printf("·  https://%s.%s/api\n", c.SubdomainName.Value, c.DomainName.Value)
+	log.Printf("·  %s\n", outputs["instance_url"])
+	log.Printf("·  %s/api\n", outputs["instance_url"])
+	if record := outputs["dns_record"]; record != "" {
+		log.Println("Create this record in your DNS, the certificate is issued once it resolves:")
+		log.Printf("·  %s\n", record)
+	}
+}
+
+// loadDeploymentConfig loads the config of a cloud deployment, given by name in
+// the ops URI or as the GCS URI of its config.
+func loadDeploymentConfig(deployment, opsURI string) *config.CloudDeploymentConfig {
+	configURI := deployment
+	if !strings.HasPrefix(deployment, "gs://") {
+		configURI = fmt.Sprintf("%s/%s", opsURI, deployment)
+	}
+
+	c, err := config.NewCloudDeploymentConfig(configURI)
+	if err != nil {
+		log.Fatalf("error loading config: %v\n", err)
+	}
+	return c
 }
 
-// ListCloud lists cloud deployments.
-func ListCloud(backend string) {
+// StopCloud stops the VM of a cloud deployment, keeping everything else.
+func StopCloud(deployment, opsURI string) {
+	c := loadDeploymentConfig(deployment, opsURI)
+
+	var err error
+	stop := func() {
+		err = housekeeping.StopCloud(c)
+	}
+	tools.RunWithSpinner(fmt.Sprintf("stopping %s", c.SubdomainName.Value), stop)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	log.Printf("Deployment %s stopped, start it again with `platform start %s`\n", c.SubdomainName.Value, c.SubdomainName.Value)
+	if c.Schedule.Value != "" {
+		log.Printf("·  it will also be started by its schedule: %s\n", c.Schedule.Value)
+	}
+}
+
+// StartCloud starts the VM of a stopped cloud deployment.
+func StartCloud(deployment, opsURI string) {
+	c := loadDeploymentConfig(deployment, opsURI)
+
+	var err error
+	start := func() {
+		err = housekeeping.StartCloud(c)
+	}
+	tools.RunWithSpinner(fmt.Sprintf("starting %s", c.SubdomainName.Value), start)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	log.Printf("Deployment %s started, the platform is available in a few minutes\n", c.SubdomainName.Value)
+}
+
+// describeMetadata returns who created a deployment and when it expires.
+func describeMetadata(m *housekeeping.Metadata) string {
+	if m.ExpiresAt == nil {
+		return fmt.Sprintf("by %s, no expiry", m.Creator)
+	}
+	return fmt.Sprintf("by %s, expires %s", m.Creator, m.ExpiresAt.Local().Format("2006-01-02 15:04"))
+}
+
+// StatusCloud shows the state of a cloud deployment along with its metadata.
+func StatusCloud(deployment, opsURI string) {
+	c := loadDeploymentConfig(deployment, opsURI)
+	configURI := fmt.Sprintf("%s/%s", c.OpsURI.Value, c.SubdomainName.Value)
+
+	var url, status string
+	var meta *housekeeping.Metadata
+	var metaErr error
+	checkInstance := func() {
+		url, status = housekeeping.CheckInstance(configURI)
+		meta, metaErr = housekeeping.ReadMetadata(c.OpsURI.Value, c.SubdomainName.Value)
+	}
+	tools.RunWithSpinner(fmt.Sprintf("checking instance %s", c.SubdomainName.Value), checkInstance)
+
+	key := lipgloss.NewStyle().Width(14).Align(lipgloss.Left).Bold(true)
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("#777777"))
+	sb := strings.Builder{}
+	row := func(k, v string) {
+		sb.WriteString(key.Render(k))
+		sb.WriteString(v)
+		sb.WriteString("\n")
+	}
+
+	row("name", c.SubdomainName.Value)
+	row("status", status)
+	row("url", url)
+	row("config", configURI)
+
+	switch {
+	case errors.Is(metaErr, storage.ErrObjectNotExist):
+		row("metadata", dim.Render("none, the deployment predates metadata documents"))
+	case metaErr != nil:
+		row("metadata", fmt.Sprintf("error: %v", metaErr))
+	default:
+		row("created", fmt.Sprintf("%s by %s", meta.CreatedAt.Local().Format("2006-01-02 15:04"), meta.Creator))
+		row("updated", fmt.Sprintf("%s by %s", meta.UpdatedAt.Local().Format("2006-01-02 15:04"), meta.UpdatedBy))
+		if meta.ExpiresAt == nil {
+			row("expires", "never")
+		} else if left := time.Until(*meta.ExpiresAt); left > 0 {
+			row("expires", fmt.Sprintf("%s (in %s)", meta.ExpiresAt.Local().Format("2006-01-02 15:04"), left.Round(time.Minute)))
+		} else {
+			row("expires", fmt.Sprintf("%s (expired)", meta.ExpiresAt.Local().Format("2006-01-02 15:04")))
+		}
+		row("tool version", meta.ToolVersion)
+		hash := meta.ConfigHash
+		if hash != housekeeping.ConfigHash(c) {
+			hash += dim.Render(" (the stored config changed since it was deployed)")
+		}
+		row("config hash", hash)
+		for _, k := range slices.Sorted(maps.Keys(meta.Outputs)) {
+			if meta.Outputs[k] != "" {
+				row(k, meta.Outputs[k])
+			}
+		}
+	}
+
+	fmt.Print(sb.String())
+}
+
+// ListCloud lists cloud deployments. With reconcile, the deployments whose VM is
+// gone are destroyed through terraform, which removes their workspace and
+// archives their config.
+func ListCloud(backend string, reconcile bool) {
 	ok := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#00ff00")).Render("✔")
 	ko := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ff0000")).Render("✘")
+	st := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ffaa00")).Render("■")
 	em := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#777777")).Render(" — ")
 	po := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ffffff")).Render("(")
 	pc := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ffffff")).Render(")")
 
-	files := []string{}
+	entries := []housekeeping.RegistryEntry{}
+	gone := []string{}
 	statuses := strings.Builder{}
 
 	getCloudDeployments := func() {
-		var err error
-		files, err = tools.ListFilesInGCSPrefix(backend)
+		r, err := housekeeping.ReadRegistry(backend)
 		if err != nil {
-			log.Fatalf("error listing files in ops uri: %v\n", err)
+			log.Fatalf("error reading deployment registry: %v\n", err)
 		}
+		entries = r.Entries()
 	}
 	tools.RunWithSpinner("getting cloud deployments", getCloudDeployments)
 
-	if len(files) == 0 {
+	if len(entries) == 0 {
 		lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ff0000")).Render("No deployments found.")
 		return
 	}
 
-	for _, f := range files {
-		if !strings.Contains(f, ".") {
-			configFilename := fmt.Sprintf("%s/%s", backend, f)
+	for _, entry := range entries {
+		configFilename := entry.Config
+		meta := entry.Metadata
 
-			var url, status string
-			checkInstance := func() {
-				url, status = housekeeping.CheckInstance(configFilename)
-			}
+		var url, status string
+		checkInstance := func() {
+			url, status = entry.CheckInstance()
+		}
 
-			tools.RunWithSpinner(fmt.Sprintf("checking instance %s", f), checkInstance)
+		tools.RunWithSpinner(fmt.Sprintf("checking instance %s", entry.Name), checkInstance)
 
-			parts := strings.Split(f, "/")
-			name := lipgloss.NewStyle().Width(16).Align(lipgloss.Left).Bold(true).Render(parts[len(parts)-1])
-			configName := lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#777777")).Render(configFilename)
-			url = lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#3366cc")).Render(url)
+		name := lipgloss.NewStyle().Width(16).Align(lipgloss.Left).Bold(true).Render(entry.Name)
+		configName := lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#777777")).Render(configFilename)
+		url = lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#3366cc")).Render(url)
 
-			if status == "live" {
-				statuses.WriteString(ok)
-			} else {
-				statuses.WriteString(ko)
-			}
-			statuses.WriteString(em)
-			statuses.WriteString(name)
-			statuses.WriteString(em)
-			if status == "live" {
-				statuses.WriteString(url)
-			} else {
-				statuses.WriteString(status)
-			}
+		switch status {
+		case "live":
+			statuses.WriteString(ok)
+		case housekeeping.InstanceStopped, housekeeping.InstancePreempted:
+			statuses.WriteString(st)
+		case housekeeping.InstanceGone:
+			statuses.WriteString(ko)
+			gone = append(gone, configFilename)
+		default:
+			statuses.WriteString(ko)
+		}
+		statuses.WriteString(em)
+		statuses.WriteString(name)
+		statuses.WriteString(em)
+		if status == "live" {
+			statuses.WriteString(url)
+		} else {
+			statuses.WriteString(status)
+		}
+		if meta != nil {
 			statuses.WriteString(em)
-			statuses.WriteString(po)
-			statuses.WriteString(configName)
-			statuses.WriteString(pc)
-			statuses.WriteString("\n")
+			statuses.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#777777")).Render(describeMetadata(meta)))
 		}
+		statuses.WriteString(em)
+		statuses.WriteString(po)
+		statuses.WriteString(configName)
+		statuses.WriteString(pc)
+		statuses.WriteString("\n")
 	}
 
 	fmt.Printf("%s\n", statuses.String())
+
+	if len(gone) == 0 {
+		return
+	}
+	if !reconcile {
+		log.Printf("%d deployments are gone, run `platform list --reconcile` to clean them up\n", len(gone))
+		return
+	}
+	for _, configFilename := range gone {
+		var err error
+		destroy := func() {
+			err = housekeeping.DestroyCloud(configFilename, nil)
+		}
+		tools.RunWithSpinner(fmt.Sprintf("reconciling %s", configFilename), destroy)
+		if err != nil {
+			log.Printf("error reconciling %s: %v\n", configFilename, err)
+			continue
+		}
+		log.Printf("reconciled %s, its config is archived\n", configFilename)
+	}
 }
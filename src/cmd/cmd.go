@@ -8,9 +8,67 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// defaultOpsURI is the GCS URI where cloud deployments are stored by default.
+const defaultOpsURI = "gs://open-targets-ops/terraform/devinstance"
+
+// Flags are bound to variables of their own command, as pflag writes the
+// default of a flag into its variable when the flag is defined, so commands
+// sharing a variable would get the defaults of each other.
 var (
-	unattended bool
-	configFile string
+	listenAddr   string
+	bootstrap    housekeeping.BootstrapConfig
+	project      string
+	release      string
+	engine       string
+	releaseURL   string
+	diskSize     int64
+	snapshotName string
+	withData     bool
+	reconcile    bool
+	dryRun       bool
+	toVersion    int
+	outputFile   string
+	jsonOutput   bool
+
+	localNoEnv bool
+
+	cloudUnattended    bool
+	cloudSkipPreflight bool
+	cloudConfigFile    string
+	cloudNoEnv         bool
+	cloudAdopt         bool
+
+	serveOpsURI     string
+	serveConfigFile string
+
+	bootstrapUnattended bool
+
+	snapshotsConfigFile string
+	snapshotsSaveOpsURI string
+
+	cloneUnattended    bool
+	cloneSkipPreflight bool
+	cloneNoEnv         bool
+	cloneOpsURI        string
+
+	statusOpsURI   string
+	historyOpsURI  string
+	reapOpsURI     string
+	reapUnlabelled bool
+	stopOpsURI     string
+	startOpsURI    string
+
+	rollbackUnattended    bool
+	rollbackSkipPreflight bool
+	rollbackOpsURI        string
+
+	configDiffOpsURI        string
+	configShowNoEnv         bool
+	configShowOpsURI        string
+	configPullOpsURI        string
+	configPushUnattended    bool
+	configPushSkipPreflight bool
+	configPushOpsURI        string
 )
 
 // RootCmd is the root command of the Open Targets Platform deployment tool.
@@ -49,14 +107,436 @@ var listCmd = &cobra.Command{
 This command takes an optional backend URI as argument, where the deployment
 state is stored. If no argument is provided, it will use the default value
 "gs://open-targets-ops/terraform/devinstance".
+
+Deployments delete their VM when they expire, and are then listed as gone.
+With --reconcile, their remaining resources are destroyed through terraform,
+their workspace removed and their config archived, so they are no longer
+listed.
 `,
 	Args: cobra.MaximumNArgs(1),
 	Run: func(_ *cobra.Command, args []string) {
-		defaultGCSPrefix := "gs://open-targets-ops/terraform/devinstance"
 		if len(args) == 0 {
-			args = append(args, defaultGCSPrefix)
+			args = append(args, defaultOpsURI)
+		}
+		ListCloud(args[0], reconcile)
+	},
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [flags]",
+	Short: "Serve a REST API to manage cloud deployments",
+	Long: `Serve a REST API to create, list and destroy cloud deployments of the Open
+Targets Platform, so they can be managed from other tools without installing
+this one.
+
+Deploy and destroy operations run in the background as jobs, whose status and
+logs can be polled. The endpoints are:
+
+  GET    /deployments         list deployments and their status
+  POST   /deployments         create a deployment, the body is a dotenv config
+                              overriding the defaults
+  DELETE /deployments/{name}  destroy a deployment
+  GET    /jobs                list jobs
+  GET    /jobs/{id}           get the status and logs of a job
+
+Deployments are kept in the ops URI of the server, so OT_OPS_URI cannot be set
+in the body.
+
+When the OT_SERVE_TOKEN environment variable is set, requests must carry it as
+a bearer token. Without it, the API is only served on localhost.
+`,
+	Example: `  $ serve
+      serves the api on localhost:8000
+
+  $ OT_SERVE_TOKEN=s3cret serve --listen :8000
+      serves the api on port 8000 of every interface, to requests with the token
+
+  $ curl -X POST -H "Authorization: Bearer s3cret" \
+      --data-binary 'OT_API_TAG="25.0.3"' localhost:8000/deployments
+      deploys an instance with the default config and a specific API tag`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		RunServe(listenAddr, serveOpsURI, serveConfigFile)
+	},
+}
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap --project <project> [flags]",
+	Short: "Create the cloud prerequisites for a project",
+	Long: `Create everything cloud deployments need in a Google Cloud project, using
+the terraform module in ./etc/bootstrap:
+
+  - a service account for the deployment VMs, with roles limited to the
+    devinstance resources they delete when they expire
+  - a network and a subnetwork in the region
+  - a firewall rule letting IAP reach the deployment VMs over ssh
+  - a secret for the OpenAI API token, and one for the credentials of basic
+    or oauth2 access, whose first versions are added by hand
+  - the ops bucket, where deployment configs and state are stored
+  - a Cloud DNS zone, if a domain name is given
+
+When done, a defaults file for the project is written, based on
+./etc/defaults-cloud. Use it with "deploy cloud --config". Without a domain
+name the defaults use the "none" DNS mode.
+
+The bootstrap state is stored as bootstrap.tfstate in the ops URI, so running
+the command again updates the same resources.
+`,
+	Example: `  $ bootstrap --project my-project
+      creates the prerequisites in my-project, and writes
+      ./etc/defaults-cloud-my-project
+
+  $ bootstrap --project my-project --domain platform.example.com -u
+      also creates a Cloud DNS zone for platform.example.com, without asking
+      for confirmation`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		RunBootstrap(bootstrapUnattended, &bootstrap)
+	},
+}
+
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots",
+	Short: "Manage data snapshots",
+	Long: `Manage the Google Cloud snapshots that hold the data of cloud deployments.
+
+Snapshots are recognized by their "engine" (clickhouse or opensearch) and
+"release" (e.g. 2509 for 25.09) labels, or else by their names following the
+platform-YYMM-ch and platform-YYMM-os convention.
+`,
+}
+
+var snapshotsListCmd = &cobra.Command{
+	Use:   "list [flags]",
+	Short: "List data snapshots",
+	Long: `List the data snapshots in a Google Cloud project, newest first, with their
+engine, release, creation date and size.
+
+The project is taken from the config file, unless --project is given.
+`,
+	Example: `  $ snapshots list --release 25.09
+      lists the snapshots of release 25.09 in the default project
+
+  $ snapshots list --project my-project --engine clickhouse
+      lists the clickhouse snapshots of every release in my-project`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		ListSnapshots(snapshotsProject(project, snapshotsConfigFile), engine, release)
+	},
+}
+
+var snapshotsCreateCmd = &cobra.Command{
+	Use:   "create <release> [flags]",
+	Short: "Build the data snapshots of a release",
+	Long: `Build the ClickHouse and OpenSearch snapshots of a data release, named
+platform-YYMM-ch and platform-YYMM-os and labelled with their release and
+engine.
+
+A temporary builder VM is created with two blank disks, which it populates from
+the release tarballs under the release URL. The disks are then snapshotted, and
+the builder VM and its disks are deleted.
+
+The project, zone, network, service account and machine type are taken from the
+config file. The release URL is taken from --release-url, the OT_RELEASE_URL
+environment variable or ./etc/defaults-local, in that order.
+`,
+	Example: `  $ snapshots create 25.09
+      builds platform-2509-ch and platform-2509-os in the default project`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		CreateSnapshots(args[0], releaseURL, diskSize, snapshotsConfigFile, project)
+	},
+}
+
+var snapshotsSaveCmd = &cobra.Command{
+	Use:   "save <deployment> --name <name> [flags]",
+	Short: "Save the data disks of a deployment as snapshots",
+	Long: `Save the ClickHouse and OpenSearch data disks of a cloud deployment as new
+snapshots, named <name>-ch and <name>-os and labelled with the deployment they
+come from and its release.
+
+The platform is stopped over ssh while the disks are snapshotted, so the data
+is consistent, and started again afterwards. The deployment can be given by
+name, looked up in the ops URI, or as the GCS URI of its config.
+`,
+	Example: `  $ snapshots save dev --name curated-2509
+      saves the data of the dev deployment as curated-2509-ch and
+      curated-2509-os, to be used as TF_VAR_OT_SNAPSHOT_CH and
+      TF_VAR_OT_SNAPSHOT_OS in another config`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		SaveSnapshots(args[0], snapshotName, snapshotsSaveOpsURI)
+	},
+}
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone <source> <new-subdomain>",
+	Short: "Clone a cloud deployment",
+	Long: `Deploy a copy of a cloud deployment under a new subdomain.
+
+The config of the source deployment is loaded from the ops URI, or from the
+given GCS URI, and deployed again with the new subdomain in a new terraform
+workspace. Environment variables override the source config like in
+'deploy cloud'.
+
+By default the clone starts from the release snapshots in the source config.
+With --with-data, the data disks of the source are snapshotted first, stopping
+the platform meanwhile, so the clone starts from the current data. The
+snapshots are deleted by 'reap' once the clone is destroyed.
+`,
+	Example: `  $ clone dev dev-copy
+      deploys dev-copy with the same config as dev
+
+  $ clone dev dev-copy --with-data
+      deploys dev-copy with the same config and data as dev`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		RunClone(args[0], args[1], withData, cloneUnattended, cloneSkipPreflight, cloneNoEnv, cloneOpsURI)
+	},
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop <deployment>",
+	Short: "Stop a cloud deployment",
+	Long: `Stop the VM of a cloud deployment to save cost while it is not used.
+
+Disks, DNS records and the config are kept, and the deployment shows as
+stopped in 'list' until it is started again with 'start'. Deployments with a
+schedule are also started by it. The deployment can be given by name, looked
+up in the ops URI, or as the GCS URI of its config.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		StopCloud(args[0], stopOpsURI)
+	},
+}
+
+var startCmd = &cobra.Command{
+	Use:   "start <deployment>",
+	Short: "Start a stopped cloud deployment",
+	Long: `Start the VM of a stopped cloud deployment. The platform is brought up again
+by the startup script once the VM boots, which takes a few minutes.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		StartCloud(args[0], startOpsURI)
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status <deployment>",
+	Short: "Show the status of a cloud deployment",
+	Long: `Show the state of a cloud deployment, along with its metadata: who created
+and last updated it and when, when it expires, the version of the tool that
+deployed it, the hash of the deployed config and the terraform outputs.
+
+The deployment can be given by name, looked up in the ops URI, or as the GCS
+URI of its config.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		StatusCloud(args[0], statusOpsURI)
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history <deployment>",
+	Short: "List the config versions of a cloud deployment",
+	Long: `List the configs a cloud deployment was deployed with, newest first, with
+who deployed each of them, when, and what changed from the version before.
+
+Every deploy that changes the config keeps a copy of it under history/ in the
+ops URI. Deployments created before history was kept have one from their next
+deploy.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		HistoryCloud(args[0], historyOpsURI)
+	},
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <deployment>",
+	Short: "Deploy a previous config version of a cloud deployment",
+	Long: `Deploy a previous config of a cloud deployment, as listed by the history
+command, the same way it is updated. By default it rolls back to the version
+before the latest one, and --to picks another one. The rollback is itself kept
+as a new version.
+`,
+	Example: `  $ rollback brisk-otter-42
+      deploys the config brisk-otter-42 had before the latest deploy
+
+  $ rollback brisk-otter-42 --to 2
+      deploys version 2 of the config of brisk-otter-42
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		RollbackCloud(args[0], toVersion, rollbackUnattended, rollbackSkipPreflight, rollbackOpsURI)
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with cloud deployment configs",
+}
+
+var configDiffCmd = &cobra.Command{
+	Use:   "diff <a> <b>",
+	Short: "Show the differences between two configs",
+	Long: `Show the settings that differ from config a to config b, grouped by the
+sections of the deployment form, and whether applying them recreates the VM or
+the data disks.
+
+Each config can be a local file, a GCS URI, the name of a deployment in the ops
+URI, or "defaults" for ./etc/defaults-cloud. Settings left empty compare equal
+to their default value.
+`,
+	Example: `  $ config diff defaults dev
+      shows how the dev deployment differs from the defaults
+
+  $ config diff dev ./config-2506
+      shows what deploying ./config-2506 would change in dev
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		RunConfigDiff(args[0], args[1], configDiffOpsURI)
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [config]",
+	Short: "Show a config with where each value comes from",
+	Long: `Show a cloud deployment config as it would be deployed, with environment
+variables applied unless --no-env is given, and the source of each value:
+default, file, env or form. With --json, the config is printed as a JSON report
+instead.
+
+The config can be a local file, a GCS URI, the name of a deployment in the ops
+URI, or "defaults" for ./etc/defaults-cloud, which is also used if it is left
+out.
+`,
+	Example: `  $ config show
+      shows the defaults, and which values the environment overrides
+
+  $ config show dev --json --no-env
+      prints the stored config of dev as a JSON report
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		side := "defaults"
+		if len(args) > 0 {
+			side = args[0]
 		}
-		ListCloud(args[0])
+		RunConfigShow(side, jsonOutput, configShowNoEnv, configShowOpsURI)
+	},
+}
+
+var configPullCmd = &cobra.Command{
+	Use:   "pull <deployment>",
+	Short: "Download the config of a cloud deployment",
+	Long: `Download the config of a cloud deployment from the ops URI into a local
+file, to be edited and pushed back with "config push". Files ending in .yaml,
+.yml or .json are written as structured configs, see "config schema".
+
+The deployment can be given by name, looked up in the ops URI, or as the GCS
+URI of its config.
+`,
+	Example: `  $ config pull dev
+      writes the config of dev to ./config-dev
+
+  $ config pull dev -o dev.yaml
+      writes the config of dev to ./dev.yaml, with the settings in sections
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		RunConfigPull(args[0], outputFile, configPullOpsURI)
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of structured configs",
+	Long: `Print the JSON Schema of YAML and JSON cloud deployment configs, generated from
+the settings, with their titles, descriptions, env names, patterns and allowed
+values. A copy is kept in ./etc/config.schema.json for editors and CI.
+
+Configs are read as YAML or JSON when their file ends in .yaml, .yml or .json,
+and as dotenv otherwise. Structured configs nest the settings in the sections
+of the form, keyed by their env name in lowercase without the TF_VAR_ and OT_
+prefixes. Keys that are not settings go under extra, by their env name. The
+config given to the VM is always dotenv.
+`,
+	Example: `  $ config schema > ./etc/config.schema.json
+      regenerates the schema after changing the settings, which
+      "go generate ./internal/config" also does
+`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		RunConfigSchema()
+	},
+}
+
+var configPushCmd = &cobra.Command{
+	Use:   "push <deployment> <file>",
+	Short: "Apply an edited config to a cloud deployment",
+	Long: `Apply a local config file to a cloud deployment. The file is validated, and
+the changes from the current config are shown for confirmation.
+
+Changes to settings that are not terraform variables, such as the software
+versions, are applied by setting the config in the VM metadata, where the
+config watcher picks it up and restarts the platform. Other changes are
+deployed through terraform. Either way, the config in the ops URI is updated
+and kept as a new version.
+`,
+	Example: `  $ config pull dev
+  $ vi ./config-dev
+  $ config push dev ./config-dev
+      applies the edited config of dev
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		RunConfigPush(args[0], args[1], configPushUnattended, configPushSkipPreflight, configPushOpsURI)
+	},
+}
+
+var reapCmd = &cobra.Command{
+	Use:   "reap",
+	Short: "Destroy expired deployments and orphaned resources",
+	Long: `Destroy the cloud deployments registered in the ops URI that have expired,
+and delete the resources they left behind.
+
+A deployment expires when its days to live have passed since it was first
+deployed, or once its VM is gone. Expired deployments are destroyed through
+terraform like with 'destroy', and their config is archived. Deployments that
+are locked, e.g. while they are being deployed, are left alone.
+
+Instances, disks, firewall rules and static addresses labelled for the ops URI
+whose deployment has no config, workspace or lock in it anymore are then
+deleted, in every project the configs point to, along with the snapshots made
+for them by 'clone --with-data', their instance schedule policies and their
+Cloud DNS records. Resources labelled for other ops URIs are never deleted.
+
+Resources named devinstance- that carry no ops label, such as those created
+before resources were labelled, are reported when no deployment in the ops URI
+claims them. They may belong to another ops URI sharing the project, so they
+are only deleted with --delete-unlabelled.
+
+The command does not ask for confirmation, so it can run on a schedule. Use
+--dry-run to only report what would be destroyed. It exits with an error
+status if anything could not be destroyed.
+`,
+	Example: `  $ reap --dry-run
+      reports the expired deployments and orphaned resources
+
+  $ reap --ops-uri gs://my-ops-bucket/deployments
+      destroys them for the deployments stored in another ops URI
+
+  $ reap --delete-unlabelled
+      also deletes the unlabelled resources it reports, once the ops URI is
+      known to be the only one using the project`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		RunReap(reapOpsURI, dryRun, reapUnlabelled)
 	},
 }
 
@@ -67,7 +547,7 @@ var localCmd = &cobra.Command{
 	Example: "deploy local",
 	Run: func(_ *cobra.Command, _ []string) {
 		// TODO: Finish local deployment.
-		RunLocal(false, "./etc/defaults-local")
+		RunLocal(false, "", localNoEnv)
 	},
 }
 
@@ -82,17 +562,25 @@ mode.
 
 You can pass a configuration file with the --config flag. In interactive mode, the
 form will be pre-filled with the the values inside. Configuration files can either
-be local files or Google Cloud Storage URIs (gs://bucket/path/to/file).
+be local files or Google Cloud Storage URIs (gs://bucket/path/to/file), in dotenv,
+or in YAML or JSON if they end in .yaml, .yml or .json (see "config schema").
 
 The tool will upload both the terraform state and the configuration file to the
 GCS URI specified in the OT_OPS_URI environment variable. Later on, it is possible
 to use the configuration file from the cloud to update an instance.
 
+A deployment whose subdomain name is already in use, by a configuration file or a
+terraform workspace in the OT_OPS_URI, is refused, unless its own configuration
+file from the cloud is used or --adopt is given to take it over. Deployments are
+locked while they are deployed or destroyed.
+
 If no configuration file is specified, the tool will use the defaults provided in
 ./etc/defaults-cloud.
 
 Any environment variables that are set when running the tool will override the
-values in the configuration file or the defaults. See examples below.
+values in the configuration file or the defaults, unless --no-env is given. The
+configuration shown before deploying marks the values that do not come from the
+defaults with their source: [file], [env] or [form]. See examples below.
 `,
 	Example: `  $ deploy cloud
       shows a form to configure the deployment
@@ -115,16 +603,92 @@ values in the configuration file or the defaults. See examples below.
       but overriding the API image tag to 'another'
 `,
 	Run: func(_ *cobra.Command, _ []string) {
-		RunCloud(unattended, configFile)
+		RunCloud(cloudUnattended, cloudConfigFile, cloudSkipPreflight, cloudAdopt, cloudNoEnv)
 	},
 }
 
 func init() {
-	cloudCmd.Flags().BoolVarP(&unattended, "unattended", "u", false, "run in unattended mode")
-	cloudCmd.Flags().StringVarP(&configFile, "config", "c", "", `Configuration file. This can be a local file or a Google
-Cloud Storage URI (gs://bucket/path/to/file). If -c is not
-specified, the tool will use the defaults values found in
+	localCmd.Flags().BoolVar(&localNoEnv, "no-env", false, "do not override values with environment variables")
+
+	cloudCmd.Flags().BoolVarP(&cloudUnattended, "unattended", "u", false, "run in unattended mode")
+	cloudCmd.Flags().StringVarP(&cloudConfigFile, "config", "c", "", `Configuration file. This can be a local file or a Google
+Cloud Storage URI (gs://bucket/path/to/file), in dotenv, or
+in YAML or JSON by its extension. If -c is not specified,
+the tool will use the defaults values found in
 ./etc/defaults-cloud.`)
+	cloudCmd.Flags().BoolVar(&cloudSkipPreflight, "skip-preflight", false, "skip the permission and quota checks")
+	cloudCmd.Flags().BoolVar(&cloudNoEnv, "no-env", false, "do not override values with environment variables")
+	cloudCmd.Flags().BoolVar(&cloudAdopt, "adopt", false, "take over an existing deployment with the same subdomain name")
+
+	listCmd.Flags().BoolVar(&reconcile, "reconcile", false, "destroy the leftovers of deployments whose VM is gone, and archive their config")
+
+	serveCmd.Flags().StringVarP(&listenAddr, "listen", "l", "localhost:8000", "address to listen on")
+	serveCmd.Flags().StringVar(&serveOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs and state are stored")
+	serveCmd.Flags().StringVarP(&serveConfigFile, "config", "c", "./etc/defaults-cloud", "configuration file with the defaults for new deployments")
+
+	bootstrapCmd.Flags().BoolVarP(&bootstrapUnattended, "unattended", "u", false, "run without asking for confirmation")
+	bootstrapCmd.Flags().StringVar(&bootstrap.Project, "project", "", "Google Cloud project to bootstrap")
+	bootstrapCmd.Flags().StringVar(&bootstrap.Region, "region", "europe-west1", "region of the network and the ops bucket")
+	bootstrapCmd.Flags().StringVar(&bootstrap.Zone, "zone", "europe-west1-d", "zone the deployments will run in")
+	bootstrapCmd.Flags().StringVar(&bootstrap.OpsURI, "ops-uri", "", `GCS URI where deployment configs and state will be stored
+(default "gs://<project>-ops/terraform/devinstance")`)
+	bootstrapCmd.Flags().StringVar(&bootstrap.ServiceAccount, "service-account", "devinstance-manager", "name of the service account to create")
+	bootstrapCmd.Flags().StringVar(&bootstrap.Network, "network", "devinstance", "name of the network and subnetwork to create")
+	bootstrapCmd.Flags().StringVar(&bootstrap.SubnetworkRange, "subnetwork-range", "10.0.0.0/24", "IP range of the subnetwork")
+	bootstrapCmd.Flags().StringVar(&bootstrap.SecretAIToken, "ai-token-secret", "openai-token", "name of the secret holding the OpenAI API token")
+	bootstrapCmd.Flags().StringVar(&bootstrap.SecretAccess, "access-secret", "platform-access", "name of the secret holding the access credentials, the VMs can read any secret it prefixes")
+	bootstrapCmd.Flags().StringVar(&bootstrap.DomainName, "domain", "", "domain to create a Cloud DNS zone for")
+	bootstrapCmd.Flags().StringVarP(&bootstrap.DefaultsPath, "output", "o", "", `path of the defaults file to write
+(default "./etc/defaults-cloud-<project>")`)
+	bootstrapCmd.Flags().StringVar(&bootstrap.DefaultsTemplate, "defaults", "./etc/defaults-cloud", "defaults file the new one is based on")
+	bootstrapCmd.MarkFlagRequired("project")
+
+	snapshotsCmd.PersistentFlags().StringVar(&project, "project", "", "Google Cloud project of the snapshots (default from the config file)")
+	snapshotsCmd.PersistentFlags().StringVarP(&snapshotsConfigFile, "config", "c", "./etc/defaults-cloud", "configuration file with the project and machine settings")
+	snapshotsListCmd.Flags().StringVar(&release, "release", "", "only list snapshots of this release, e.g. 25.09")
+	snapshotsListCmd.Flags().StringVar(&engine, "engine", "", "only list snapshots of this engine, clickhouse or opensearch")
+
+	snapshotsCreateCmd.Flags().StringVar(&releaseURL, "release-url", "", "URL the release tarballs are downloaded from")
+	snapshotsCreateCmd.Flags().Int64Var(&diskSize, "disk-size", 200, "size in GB of the disks the snapshots are taken from")
+
+	snapshotsSaveCmd.Flags().StringVar(&snapshotName, "name", "", "name of the snapshots, suffixed with -ch and -os")
+	snapshotsSaveCmd.Flags().StringVar(&snapshotsSaveOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+	snapshotsSaveCmd.MarkFlagRequired("name")
+
+	cloneCmd.Flags().BoolVar(&withData, "with-data", false, "start the clone from snapshots of the source data disks")
+	cloneCmd.Flags().BoolVarP(&cloneUnattended, "unattended", "u", false, "run without asking for confirmation")
+	cloneCmd.Flags().BoolVar(&cloneSkipPreflight, "skip-preflight", false, "skip the permission and quota checks")
+	cloneCmd.Flags().BoolVar(&cloneNoEnv, "no-env", false, "do not override values with environment variables")
+	cloneCmd.Flags().StringVar(&cloneOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+
+	statusCmd.Flags().StringVar(&statusOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+
+	configDiffCmd.Flags().StringVar(&configDiffOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+
+	configShowCmd.Flags().BoolVar(&jsonOutput, "json", false, "print the config as a JSON report")
+	configShowCmd.Flags().BoolVar(&configShowNoEnv, "no-env", false, "do not override values with environment variables")
+	configShowCmd.Flags().StringVar(&configShowOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+
+	configPullCmd.Flags().StringVarP(&outputFile, "output", "o", "", "file to write the config to, ./config-<deployment> by default")
+	configPullCmd.Flags().StringVar(&configPullOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+
+	configPushCmd.Flags().BoolVarP(&configPushUnattended, "unattended", "u", false, "run in unattended mode")
+	configPushCmd.Flags().BoolVar(&configPushSkipPreflight, "skip-preflight", false, "skip the permission and quota checks")
+	configPushCmd.Flags().StringVar(&configPushOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+
+	historyCmd.Flags().StringVar(&historyOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+
+	rollbackCmd.Flags().IntVar(&toVersion, "to", 0, "version to roll back to, by default the one before the latest")
+	rollbackCmd.Flags().BoolVarP(&rollbackUnattended, "unattended", "u", false, "run in unattended mode")
+	rollbackCmd.Flags().BoolVar(&rollbackSkipPreflight, "skip-preflight", false, "skip the permission and quota checks")
+	rollbackCmd.Flags().StringVar(&rollbackOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+
+	reapCmd.Flags().BoolVar(&dryRun, "dry-run", false, "only report what would be destroyed")
+	reapCmd.Flags().StringVar(&reapOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs and state are stored")
+	reapCmd.Flags().BoolVar(&reapUnlabelled, "delete-unlabelled", false, "also delete orphaned devinstance- resources that carry no ops label")
+
+	stopCmd.Flags().StringVar(&stopOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
+	startCmd.Flags().StringVar(&startOpsURI, "ops-uri", defaultOpsURI, "GCS URI where deployment configs are stored")
 
 	RootCmd.AddGroup(&cobra.Group{
 		ID:    "main",
@@ -134,6 +698,17 @@ specified, the tool will use the defaults values found in
 	deployCmd.GroupID = "main"
 	destroyCmd.GroupID = "main"
 	listCmd.GroupID = "main"
+	serveCmd.GroupID = "main"
+	bootstrapCmd.GroupID = "main"
+	snapshotsCmd.GroupID = "main"
+	cloneCmd.GroupID = "main"
+	stopCmd.GroupID = "main"
+	reapCmd.GroupID = "main"
+	statusCmd.GroupID = "main"
+	historyCmd.GroupID = "main"
+	configCmd.GroupID = "main"
+	rollbackCmd.GroupID = "main"
+	startCmd.GroupID = "main"
 
 	deployCmd.AddGroup(&cobra.Group{
 		ID:    "deploy",
@@ -146,6 +721,25 @@ specified, the tool will use the defaults values found in
 	RootCmd.AddCommand(deployCmd)
 	RootCmd.AddCommand(destroyCmd)
 	RootCmd.AddCommand(listCmd)
+	RootCmd.AddCommand(serveCmd)
+	RootCmd.AddCommand(bootstrapCmd)
+	RootCmd.AddCommand(snapshotsCmd)
+	RootCmd.AddCommand(cloneCmd)
+	RootCmd.AddCommand(stopCmd)
+	RootCmd.AddCommand(reapCmd)
+	RootCmd.AddCommand(statusCmd)
+	RootCmd.AddCommand(historyCmd)
+	RootCmd.AddCommand(configCmd)
+	RootCmd.AddCommand(rollbackCmd)
+	RootCmd.AddCommand(startCmd)
 	deployCmd.AddCommand(localCmd)
 	deployCmd.AddCommand(cloudCmd)
+	configCmd.AddCommand(configDiffCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configPullCmd)
+	configCmd.AddCommand(configPushCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	snapshotsCmd.AddCommand(snapshotsListCmd)
+	snapshotsCmd.AddCommand(snapshotsCreateCmd)
+	snapshotsCmd.AddCommand(snapshotsSaveCmd)
 }
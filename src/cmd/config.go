@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/housekeeping"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// resolveConfigPath returns the path of a config given as a local file, a GCS
+// URI, a deployment name in the ops URI, or `defaults` for the cloud defaults.
+func resolveConfigPath(side, opsURI string) string {
+	switch {
+	case side == "defaults":
+		return config.DefaultsCloudPath
+	case strings.HasPrefix(side, "gs://"):
+		return side
+	}
+	if _, err := os.Stat(side); err == nil {
+		return side
+	}
+	return fmt.Sprintf("%s/%s", opsURI, side)
+}
+
+// loadConfigForDiff loads a config and normalizes it through the settings, so
+// values left to their defaults compare equal to the defaults.
+func loadConfigForDiff(path string) (*config.CloudDeploymentConfig, error) {
+	f, err := config.LoadConfigFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	env := f.Map()
+	c, err := config.NewCloudDeploymentConfigFromEnv(env)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	// An empty subdomain name is only filled in with a random one to deploy.
+	if env[c.SubdomainName.Env] == "" {
+		c.SubdomainName.Value = ""
+	}
+	return c, nil
+}
+
+// formatDiff returns the changes between two configs grouped by section, with
+// the settings whose change replaces part of the deployment flagged, followed
+// by the changes to keys that are not settings.
+func formatDiff(diff []config.SectionChanges, extra []config.ExtraChange) string {
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("#777777"))
+	warn := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ffaa00"))
+	title := lipgloss.NewStyle().Bold(true)
+
+	sb := strings.Builder{}
+	for _, section := range diff {
+		sb.WriteString(title.Render(section.Title) + "\n")
+		for _, ch := range section.Changes {
+			sb.WriteString(fmt.Sprintf("·  %s %s: %s %s %s", ch.Setting.Title, dim.Render("("+ch.Setting.Env+")"), quoteValue(ch.Old), dim.Render("→"), quoteValue(ch.New)))
+			if ch.Setting.Recreates != "" {
+				sb.WriteString(warn.Render(fmt.Sprintf(" recreates %s", ch.Setting.Recreates)))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	if len(extra) > 0 {
+		// Keys that are not settings are listed as a section of their own.
+		sb.WriteString(title.Render("Other keys") + "\n")
+		for _, ch := range extra {
+			sb.WriteString(fmt.Sprintf("·  %s: %s %s %s\n", ch.Key, quoteValue(ch.Old), dim.Render("→"), quoteValue(ch.New)))
+		}
+	}
+
+	sb.WriteString("\n")
+	switch config.Recreates(diff) {
+	case config.RecreatesDisks:
+		sb.WriteString(warn.Render("Applying these changes recreates the data disks, and the data changed on them is lost.") + "\n")
+	case config.RecreatesVM:
+		sb.WriteString(warn.Render("Applying these changes recreates the VM, the data disks are kept.") + "\n")
+	default:
+		sb.WriteString(dim.Render("These changes are applied without recreating the VM or the data disks.") + "\n")
+	}
+	return sb.String()
+}
+
+// RunConfigDiff prints the differences between two cloud deployment configs,
+// each given as a local file, a GCS URI, a deployment name or `defaults`.
+func RunConfigDiff(a, b, opsURI string) {
+	pathA, pathB := resolveConfigPath(a, opsURI), resolveConfigPath(b, opsURI)
+
+	var ca, cb *config.CloudDeploymentConfig
+	var errA, errB error
+	load := func() {
+		ca, errA = loadConfigForDiff(pathA)
+		cb, errB = loadConfigForDiff(pathB)
+	}
+	tools.RunWithSpinner("loading configs", load)
+	for _, err := range []error{errA, errB} {
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	diff, extra := config.Diff(ca, cb), config.DiffExtra(ca, cb)
+	if len(diff) == 0 && len(extra) == 0 {
+		log.Printf("%s and %s are the same\n", pathA, pathB)
+		return
+	}
+	fmt.Printf("Changes from %s to %s\n\n%s", pathA, pathB, formatDiff(diff, extra))
+}
+
+// RunConfigPull downloads the config of a cloud deployment into a local file,
+// by default ./config-<name>, as YAML or JSON if the file has their extension.
+// Existing files are not overwritten.
+func RunConfigPull(deployment, output, opsURI string) {
+	configURI := deployment
+	if !strings.HasPrefix(deployment, "gs://") {
+		configURI = fmt.Sprintf("%s/%s", opsURI, deployment)
+	}
+	if output == "" {
+		output = fmt.Sprintf("./config-%s", path.Base(configURI))
+	}
+	if _, err := os.Stat(output); err == nil {
+		log.Fatalf("%s already exists, remove it or choose another file with --output\n", output)
+	}
+
+	// Configs are stored as dotenv, and converted when pulled into a YAML or
+	// JSON file.
+	var content string
+	var err error
+	read := func() {
+		format := config.ConfigFormat(output)
+		if format == config.FormatDotenv {
+			content, err = tools.ReadFileFromGCS(configURI)
+			return
+		}
+		var c *config.CloudDeploymentConfig
+		if c, err = config.NewCloudDeploymentConfig(configURI); err != nil {
+			return
+		}
+		var out []byte
+		out, err = c.MarshalStructured(format)
+		content = string(out)
+	}
+	tools.RunWithSpinner(fmt.Sprintf("downloading %s", configURI), read)
+	if err != nil {
+		log.Fatalf("error reading config %s: %v\n", configURI, err)
+	}
+	if err := os.WriteFile(output, []byte(content), 0644); err != nil {
+		log.Fatalf("error writing %s: %v\n", output, err)
+	}
+
+	log.Printf("Config of %s written to %s, push it back with `platform config push %s %s`\n", deployment, output, deployment, output)
+}
+
+// RunConfigPush applies a local config file to a cloud deployment, after showing
+// what changes. Changes the config watcher on the VM picks up are pushed to the
+// VM metadata directly, and the rest are deployed through terraform.
+func RunConfigPush(deployment, file string, auto, skipPreflight bool, opsURI string) {
+	// 1. Load the current and new configs
+	c := loadDeploymentConfig(deployment, opsURI)
+	existing := findExisting(c)
+	nc, err := config.NewCloudDeploymentConfig(file)
+	if err != nil {
+		log.Fatalf("error loading %s: %v\n", file, err)
+	}
+	if nc.SubdomainName.Value != c.SubdomainName.Value || nc.OpsURI.Value != c.OpsURI.Value {
+		log.Fatalf("%s is the config of another deployment, %s/%s\n", file, nc.OpsURI.Value, nc.SubdomainName.Value)
+	}
+
+	// 2. Validate the new config, and show what changes
+	if err := nc.Validate(); err != nil {
+		log.Fatalf("bad configuration: %v\n", err)
+	}
+	// Keys that are not settings, such as image tags, are only read by the
+	// config watcher, so changes to them are pushed too.
+	diff, extra := config.Diff(c, nc), config.DiffExtra(c, nc)
+	if len(diff) == 0 && len(extra) == 0 {
+		log.Printf("%s is the same as the config of %s, nothing to push\n", file, c.SubdomainName.Value)
+		return
+	}
+	fmt.Printf("Changes to %s\n\n%s", c.SubdomainName.Value, formatDiff(diff, extra))
+	viaTerraform := config.NeedsTerraform(diff)
+	if viaTerraform {
+		log.Println("some of these settings are applied by terraform, so the deployment is updated with it")
+		if !skipPreflight {
+			preflightCloud(nc)
+		}
+	}
+	if !auto {
+		confirmCloud("exiting without pushing")
+	}
+
+	// 3. Apply the changes
+	if viaTerraform {
+		deployCloud(nc, existing)
+		return
+	}
+	push := func() {
+		err = housekeeping.PushConfig(nc, existing)
+	}
+	tools.RunWithSpinner(fmt.Sprintf("pushing config to %s", c.SubdomainName.Value), push)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	log.Printf("Config pushed, %s picks it up within a minute and restarts the platform\n", c.SubdomainName.Value)
+}
+
+// RunConfigShow prints a cloud deployment config with the source of each value,
+// or as a JSON report with jsonOutput.
+func RunConfigShow(side string, jsonOutput, noEnv bool, opsURI string) {
+	configPath := ""
+	if side != "defaults" {
+		configPath = resolveConfigPath(side, opsURI)
+	}
+
+	c, err := config.NewCloudDeploymentConfig(configPath)
+	if err != nil {
+		log.Fatalf("error loading config: %v\n", err)
+	}
+	if !noEnv {
+		c.ReplaceFromEnv()
+	}
+
+	if jsonOutput {
+		out, err := json.MarshalIndent(config.Report(c), "", "  ")
+		if err != nil {
+			log.Fatalf("error encoding report: %v\n", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Print(config.Summary(c))
+}
+
+// RunConfigSchema prints the JSON Schema of structured cloud deployment configs.
+func RunConfigSchema() {
+	out, err := config.CloudConfigSchema()
+	if err != nil {
+		log.Fatalf("error generating schema: %v\n", err)
+	}
+	fmt.Print(string(out))
+}
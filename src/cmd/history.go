@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/housekeeping"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// readHistory reads the metadata of a cloud deployment, and exits if it has no
+// config history.
+func readHistory(c *config.CloudDeploymentConfig) *housekeeping.Metadata {
+	var meta *housekeeping.Metadata
+	var err error
+	read := func() {
+		meta, err = housekeeping.ReadMetadata(c.OpsURI.Value, c.SubdomainName.Value)
+	}
+	tools.RunWithSpinner(fmt.Sprintf("reading history of %s", c.SubdomainName.Value), read)
+	if errors.Is(err, storage.ErrObjectNotExist) || (err == nil && len(meta.History) == 0) {
+		log.Fatalf("deployment %s has no config history yet, it is kept from its next deploy\n", c.SubdomainName.Value)
+	}
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return meta
+}
+
+// formatChanges returns the changes between two configs, one per line.
+func formatChanges(changes []housekeeping.ConfigChange, indent string) string {
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("#777777"))
+	sb := strings.Builder{}
+	for _, ch := range changes {
+		sb.WriteString(fmt.Sprintf("%s%s: %s %s %s\n", indent, ch.Key, quoteValue(ch.Old), dim.Render("→"), quoteValue(ch.New)))
+	}
+	return sb.String()
+}
+
+// quoteValue quotes a config value for display, so empty values are visible.
+func quoteValue(v string) string {
+	return fmt.Sprintf("%q", v)
+}
+
+// HistoryCloud lists the config versions of a cloud deployment, with their
+// author and what changed from the previous version.
+func HistoryCloud(deployment, opsURI string) {
+	c := loadDeploymentConfig(deployment, opsURI)
+	meta := readHistory(c)
+
+	em := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#777777")).Render(" — ")
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("#777777"))
+	current := housekeeping.ConfigHash(c)
+
+	var contents []string
+	var err error
+	read := func() {
+		for _, v := range meta.History {
+			var content string
+			content, err = tools.ReadFileFromGCS(v.Config)
+			if err != nil {
+				err = fmt.Errorf("error reading version %d: %w", v.Version, err)
+				return
+			}
+			contents = append(contents, content)
+		}
+	}
+	tools.RunWithSpinner(fmt.Sprintf("reading versions of %s", c.SubdomainName.Value), read)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// A rollback brings back the config of an older version, so only the
+	// newest version with the current config is the deployed one.
+	currentFound := false
+	sb := strings.Builder{}
+	for i, v := range slices.Backward(meta.History) {
+		sb.WriteString(lipgloss.NewStyle().Width(4).Bold(true).Render(fmt.Sprintf("v%d", v.Version)))
+		sb.WriteString(em)
+		sb.WriteString(v.CreatedAt.Local().Format("2006-01-02 15:04"))
+		sb.WriteString(em)
+		sb.WriteString(v.Author)
+		if v.ConfigHash == current && !currentFound {
+			sb.WriteString(dim.Render(" (current)"))
+			currentFound = true
+		}
+		sb.WriteString("\n")
+
+		if i == 0 {
+			sb.WriteString(dim.Render("      first version") + "\n")
+			continue
+		}
+		changes, err := housekeeping.DiffConfigs(contents[i-1], contents[i])
+		if err != nil {
+			log.Fatalf("error comparing version %d: %v\n", v.Version, err)
+		}
+		if len(changes) == 0 {
+			sb.WriteString(dim.Render("      no changes") + "\n")
+		}
+		sb.WriteString(formatChanges(changes, "      "))
+	}
+
+	fmt.Print(sb.String())
+}
+
+// RollbackCloud deploys a previous config version of a cloud deployment, by
+// default the one before the latest, the same way the deployment is updated.
+func RollbackCloud(deployment string, to int, auto, skipPreflight bool, opsURI string) {
+	// 1. Find the version to roll back to
+	c := loadDeploymentConfig(deployment, opsURI)
+	meta := readHistory(c)
+	if to == 0 {
+		to = meta.History[len(meta.History)-1].Version - 1
+		if to == 0 {
+			log.Fatalf("deployment %s has a single config version, there is nothing to roll back to\n", c.SubdomainName.Value)
+		}
+	}
+	v, err := housekeeping.FindVersion(meta, to)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if v.ConfigHash == housekeeping.ConfigHash(c) {
+		log.Fatalf("deployment %s already has the config of version %d\n", c.SubdomainName.Value, to)
+	}
+
+	// 2. Load it, and validate it as it will be deployed
+	rc, err := config.NewCloudDeploymentConfig(v.Config)
+	if err != nil {
+		log.Fatalf("error loading version %d: %v\n", to, err)
+	}
+	if rc.SubdomainName.Value != c.SubdomainName.Value || rc.OpsURI.Value != c.OpsURI.Value {
+		log.Fatalf("version %d is the config of another deployment, %s/%s\n", to, rc.OpsURI.Value, rc.SubdomainName.Value)
+	}
+	if err := rc.Validate(); err != nil {
+		log.Fatalf("bad configuration in version %d: %v\n", to, err)
+	}
+	if !skipPreflight {
+		preflightCloud(rc)
+	}
+
+	// 3. Print what changes, and if interactive, request confirmation.
+	changes, err := housekeeping.DiffConfigs(c.ToString(), rc.ToString())
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	log.Printf("rolling %s back to version %d, by %s on %s:\n%s", c.SubdomainName.Value, to, v.Author, v.CreatedAt.Local().Format("2006-01-02 15:04"), formatChanges(changes, "·  "))
+	if !auto {
+		confirmCloud("exiting without rolling back")
+	}
+
+	// 4. Deploy over the current config, which becomes a new version
+	deployCloud(rc, findExisting(rc))
+}
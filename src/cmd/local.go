@@ -8,7 +8,7 @@ import (
 )
 
 // RunLocal runs the local deployment setup.
-func RunLocal(auto bool, configPath string) {
+func RunLocal(auto bool, configPath string, noEnv bool) {
 	// 1. Load defaults
 	c, err := config.NewLocalDeploymentConfig(configPath)
 	if err != nil {
@@ -16,7 +16,9 @@ func RunLocal(auto bool, configPath string) {
 	}
 
 	// 2. Parse env vars
-	c.ReplaceFromEnv()
+	if !noEnv {
+		c.ReplaceFromEnv()
+	}
 
 	// 3. If non-interactive mode, validate the config and exit if there are errors.
 	// Otherwise, present the configuration form.
@@ -27,15 +29,19 @@ func RunLocal(auto bool, configPath string) {
 		}
 	} else {
 		cf := config.LocalDeploymentForm(c)
-		err = cf.Run()
+		err = config.RunForm(cf, c)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 	}
 
 	// 4. Prepare deployment directory
-	housekeeping.PrepareDeploymentDir(c)
-	housekeeping.WriteConfig(c)
+	if err := housekeeping.PrepareDeploymentDir(c); err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := housekeeping.WriteConfig(c); err != nil {
+		log.Fatal(err.Error())
+	}
 
 	// 5. Run deployment
 	housekeeping.DeployLocal(c)
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opentargets/platform-deployment-standalone/internal/housekeeping"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// RunReap destroys the expired deployments in an ops URI and deletes orphaned
+// resources, then prints a report. It exits with an error status if anything
+// failed, so scheduled runs are noticed. Unlabelled orphans are only deleted
+// with deleteUnlabelled.
+func RunReap(opsURI string, dryRun, deleteUnlabelled bool) {
+	var report *housekeeping.ReapReport
+	var err error
+	action := func() {
+		report, err = housekeeping.Reap(opsURI, dryRun, deleteUnlabelled, nil)
+	}
+	title := "reaping deployments"
+	if dryRun {
+		title = "looking for expired deployments and orphaned resources"
+	}
+	tools.RunWithSpinner(title, action)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	ok := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#00ff00")).Render("✔")
+	ko := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ff0000")).Render("✘")
+	dr := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ffaa00")).Render("■")
+	em := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#777777")).Render(" — ")
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("#777777"))
+
+	mark := func(err error) string {
+		switch {
+		case dryRun:
+			return dr
+		case err != nil:
+			return ko
+		}
+		return ok
+	}
+
+	failed := len(report.Errors) > 0
+	sb := strings.Builder{}
+	if dryRun {
+		sb.WriteString(dim.Render("dry run, nothing was destroyed or deleted") + "\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("\nExpired deployments (%d)\n", len(report.Expired)))
+	for _, d := range report.Expired {
+		sb.WriteString(mark(d.Err))
+		sb.WriteString(em)
+		sb.WriteString(lipgloss.NewStyle().Width(16).Align(lipgloss.Left).Bold(true).Render(d.Name))
+		sb.WriteString(em)
+		sb.WriteString(d.Reason)
+		if d.Err != nil {
+			sb.WriteString(em)
+			sb.WriteString(d.Err.Error())
+			failed = true
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("\nOrphaned resources (%d)\n", len(report.Orphans)))
+	for _, o := range report.Orphans {
+		kept := o.Unlabelled && !report.DeleteUnlabelled
+		if kept {
+			sb.WriteString(dr)
+		} else {
+			sb.WriteString(mark(o.Err))
+		}
+		sb.WriteString(em)
+		sb.WriteString(lipgloss.NewStyle().Width(10).Align(lipgloss.Left).Render(o.Kind))
+		sb.WriteString(em)
+		sb.WriteString(lipgloss.NewStyle().Width(40).Align(lipgloss.Left).Bold(true).Render(o.Name))
+		sb.WriteString(em)
+		sb.WriteString(dim.Render(fmt.Sprintf("%s/%s", o.Project, o.Location)))
+		if kept {
+			sb.WriteString(em)
+			sb.WriteString(dim.Render("unlabelled, kept without --delete-unlabelled"))
+		} else if o.Unlabelled {
+			sb.WriteString(em)
+			sb.WriteString(dim.Render("unlabelled"))
+		}
+		if o.Err != nil {
+			sb.WriteString(em)
+			sb.WriteString(o.Err.Error())
+			failed = true
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(report.Errors) > 0 {
+		sb.WriteString(fmt.Sprintf("\nErrors (%d)\n", len(report.Errors)))
+		for _, e := range report.Errors {
+			sb.WriteString(ko)
+			sb.WriteString(em)
+			sb.WriteString(e.Error())
+			sb.WriteString("\n")
+		}
+	}
+
+	fmt.Print(sb.String())
+	if failed {
+		os.Exit(1)
+	}
+}
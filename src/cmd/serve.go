@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/opentargets/platform-deployment-standalone/internal/server"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// serveTokenEnv is the environment variable holding the bearer token of the
+// deployment REST API.
+const serveTokenEnv = "OT_SERVE_TOKEN"
+
+// RunServe serves the deployment REST API.
+func RunServe(addr, opsURI, defaultsPath string) {
+	// There is no terminal to draw spinners on, and jobs run concurrently.
+	tools.DisableSpinners()
+
+	s := server.New(opsURI, defaultsPath, os.Getenv(serveTokenEnv))
+	if err := s.ListenAndServe(addr); err != nil {
+		log.Fatalf("error serving api: %v\n", err)
+	}
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/housekeeping"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// snapshotsProject returns the project snapshots are managed in, taken from the
+// config file unless it is given explicitly.
+func snapshotsProject(project, configPath string) string {
+	if project != "" {
+		return project
+	}
+	env, err := tools.LoadEnvFromFile(configPath)
+	if err != nil {
+		log.Fatalf("error loading config: %v\n", err)
+	}
+	if env["TF_VAR_OT_GCP_PROJECT"] == "" {
+		log.Fatalf("no gcp project in %s, use --project\n", configPath)
+	}
+	return env["TF_VAR_OT_GCP_PROJECT"]
+}
+
+// ListSnapshots lists the data snapshots in a project.
+func ListSnapshots(project, engine, release string) {
+	var snapshots []config.Snapshot
+	var err error
+	action := func() {
+		snapshots, err = config.ListSnapshots(project, engine, release)
+	}
+	tools.RunWithSpinner(fmt.Sprintf("listing snapshots in %s", project), action)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Println(lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ff0000")).Render("No snapshots found."))
+		return
+	}
+
+	em := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#777777")).Render(" — ")
+	sb := strings.Builder{}
+	for _, s := range snapshots {
+		sb.WriteString(lipgloss.NewStyle().Width(32).Align(lipgloss.Left).Bold(true).Render(s.Name))
+		sb.WriteString(em)
+		sb.WriteString(lipgloss.NewStyle().Width(10).Align(lipgloss.Left).Render(s.Engine))
+		sb.WriteString(em)
+		sb.WriteString(lipgloss.NewStyle().Width(5).Align(lipgloss.Left).Render(tools.Either(s.Release, "?")))
+		sb.WriteString(em)
+		sb.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#777777")).Render(fmt.Sprintf("%s, %d GB", s.CreatedAt.Format("2006-01-02"), s.SizeGB)))
+		sb.WriteString("\n")
+	}
+	fmt.Print(sb.String())
+}
+
+// CreateSnapshots builds the data snapshots of a release in the project of the
+// cloud config.
+func CreateSnapshots(release, releaseURL string, diskSizeGB int64, configPath, project string) {
+	if err := config.ValidateRelease(release); err != nil {
+		log.Fatalf("invalid release: %v\n", err)
+	}
+
+	c, err := config.NewCloudDeploymentConfig(configPath)
+	if err != nil {
+		log.Fatalf("error loading config: %v\n", err)
+	}
+	c.ReplaceFromEnv()
+
+	// The release URL is a local deployment setting, so it is taken from the
+	// environment or the local defaults unless given explicitly.
+	if releaseURL == "" {
+		releaseURL = os.Getenv("OT_RELEASE_URL")
+	}
+	if releaseURL == "" {
+		env, err := tools.LoadEnvFromFile("./etc/defaults-local")
+		if err != nil {
+			log.Fatalf("error loading local defaults: %v\n", err)
+		}
+		releaseURL = env["OT_RELEASE_URL"]
+	}
+	if err := config.ValidateURL(releaseURL); err != nil {
+		log.Fatalf("invalid release url: %v\n", err)
+	}
+
+	b := &housekeeping.SnapshotBuild{
+		Project:        tools.Either(project, c.GCPProject.Value),
+		Zone:           c.GCPZone.Value,
+		Network:        c.GCPNetwork.Value,
+		ServiceAccount: c.GCPServiceAccount.Value,
+		MachineType:    c.MachineType.Value,
+		Release:        release,
+		ReleaseURL:     releaseURL,
+		DiskSizeGB:     diskSizeGB,
+	}
+
+	log.Printf("building snapshots of release %s in %s from %s\n", release, b.Project, releaseURL)
+	names, err := housekeeping.CreateSnapshots(b, log.Writer())
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	log.Println("Snapshots created successfully! Use them in a config with:")
+	log.Printf("·  TF_VAR_OT_SNAPSHOT_CH=\"%s\"\n", names[0])
+	log.Printf("·  TF_VAR_OT_SNAPSHOT_OS=\"%s\"\n", names[1])
+}
+
+// SaveSnapshots snapshots the data disks of a cloud deployment.
+func SaveSnapshots(deployment, name, opsURI string) {
+	c := loadDeploymentConfig(deployment, opsURI)
+
+	log.Printf("saving the data disks of %s as %s\n", c.SubdomainName.Value, name)
+	names, err := housekeeping.SaveSnapshots(c, name, nil, log.Writer())
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	log.Println("Snapshots saved successfully! Use them in a config with:")
+	log.Printf("·  TF_VAR_OT_SNAPSHOT_CH=\"%s\"\n", names[0])
+	log.Printf("·  TF_VAR_OT_SNAPSHOT_OS=\"%s\"\n", names[1])
+}
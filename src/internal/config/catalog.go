@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/iterator"
+)
+
+// Engines whose data is kept in snapshots, as used in the engine label.
+const (
+	EngineClickHouse = "clickhouse"
+	EngineOpenSearch = "opensearch"
+)
+
+// snapshotKindEngines maps snapshot name suffixes to engines.
+var snapshotKindEngines = map[string]string{
+	SnapshotKindClickHouse: EngineClickHouse,
+	SnapshotKindOpenSearch: EngineOpenSearch,
+}
+
+// snapshotNamePattern matches the conventional snapshot names, e.g.
+// `platform-2509-ch`.
+var snapshotNamePattern = regexp.MustCompile(`^platform-(\d{2})(\d{2})-(ch|os)$`)
+
+// Snapshot is a data snapshot that can back a deployment's data disk.
+type Snapshot struct {
+	Name      string
+	Engine    string
+	Release   string
+	CreatedAt time.Time
+	SizeGB    int64
+	Labels    map[string]string
+}
+
+// Describe returns a short description of the snapshot for lists and selects.
+func (s Snapshot) Describe() string {
+	release := s.Release
+	if release == "" {
+		release = "unknown release"
+	}
+	return fmt.Sprintf("%s (%s, %s, %d GB)", s.Name, release, s.CreatedAt.Format("2006-01-02"), s.SizeGB)
+}
+
+// ListSnapshots returns the data snapshots in a project, newest first. Snapshots
+// are recognized by their engine and release labels, or else by the naming
+// convention. Empty engine or release filters match every snapshot.
+func ListSnapshots(project, engine, release string) ([]Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	client, err := compute.NewSnapshotsRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	var snapshots []Snapshot
+	it := client.List(ctx, &computepb.ListSnapshotsRequest{Project: project})
+	for {
+		s, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing snapshots in %s: %w", project, err)
+		}
+
+		snapshot, ok := newSnapshot(s)
+		if !ok {
+			continue
+		}
+		if engine != "" && snapshot.Engine != engine {
+			continue
+		}
+		if release != "" && snapshot.Release != release {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	slices.SortFunc(snapshots, func(a, b Snapshot) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return snapshots, nil
+}
+
+// newSnapshot returns the data snapshot described by a GCP snapshot, or false if
+// it is not a data snapshot.
+func newSnapshot(s *computepb.Snapshot) (Snapshot, bool) {
+	labels := s.GetLabels()
+	snapshot := Snapshot{
+		Name:    s.GetName(),
+		Engine:  labels["engine"],
+		Release: releaseFromLabel(labels["release"]),
+		SizeGB:  s.GetDiskSizeGb(),
+		Labels:  labels,
+	}
+	snapshot.CreatedAt, _ = time.Parse(time.RFC3339, s.GetCreationTimestamp())
+
+	if m := snapshotNamePattern.FindStringSubmatch(snapshot.Name); m != nil {
+		if snapshot.Engine == "" {
+			snapshot.Engine = snapshotKindEngines[m[3]]
+		}
+		if snapshot.Release == "" {
+			snapshot.Release = m[1] + "." + m[2]
+		}
+	}
+
+	return snapshot, snapshot.Engine != ""
+}
+
+// releaseFromLabel turns a release label back into a release, e.g. `2509` into
+// `25.09`.
+func releaseFromLabel(label string) string {
+	if len(label) != 4 {
+		return label
+	}
+	return label[:2] + "." + label[2:]
+}
@@ -1,59 +1,119 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"os"
 	"strings"
 
 	"github.com/charmbracelet/huh"
 	"github.com/opentargets/platform-deployment-standalone/internal/tools"
 )
 
-// defaultsCloudPath is the default path to the cloud deployment configuration file.
-const defaultsCloudPath = "./etc/defaults-cloud"
+// DefaultsCloudPath is the default path to the cloud deployment configuration file.
+const DefaultsCloudPath = "./etc/defaults-cloud"
+
+// terraformVarPrefix is the prefix of settings that are terraform input variables.
+const terraformVarPrefix = "TF_VAR_"
 
 // CloudDeploymentMaxDaysToLive is the maximum number of days a cloud deployment can live.
 const CloudDeploymentMaxDaysToLive = 14
 
+// CloudDeploymentMaxDiskExtraSize is the maximum number of GB that can be added to a data disk.
+const CloudDeploymentMaxDiskExtraSize = 2000
+
 // CloudDeploymentConfig holds the configuration for a cloud deployment.
 type CloudDeploymentConfig struct {
-	DeploymentType    Setting
-	GCPProject        Setting
-	GCPRegion         Setting
-	GCPZone           Setting
-	OpsURI            Setting
-	DomainName        Setting
-	SubdomainName     Setting
-	DaysToLive        Setting
-	WebAppFlavor      Setting
-	SnapshotCH        Setting
-	SnapshotOS        Setting
-	APIImage          Setting
-	APITag            Setting
-	Release           Setting
-	APIAIImage        Setting
-	APIAITag          Setting
-	WebAppImage       Setting
-	WebAppTag         Setting
-	ClickhouseTag     Setting
-	OpensearchTag     Setting
-	GCPSecretAIToken  Setting
-	GCPCloudDNSZone   Setting
-	GCPNetwork        Setting
-	GCPServiceAccount Setting
-	APICache          Setting
+	DeploymentType      Setting
+	GCPProject          Setting
+	GCPRegion           Setting
+	GCPZone             Setting
+	OpsURI              Setting
+	DomainName          Setting
+	SubdomainName       Setting
+	DaysToLive          Setting
+	WebAppFlavor        Setting
+	DNSMode             Setting
+	AllowedSourceRanges Setting
+	AccessMode          Setting
+	GCPSecretAccess     Setting
+	MachineType         Setting
+	ProvisioningModel   Setting
+	Schedule            Setting
+	BootImage           Setting
+	DataDiskType        Setting
+	DataDiskExtraSize   Setting
+	SnapshotCH          Setting
+	SnapshotOS          Setting
+	APIImage            Setting
+	APITag              Setting
+	Release             Setting
+	APIAIImage          Setting
+	APIAITag            Setting
+	WebAppImage         Setting
+	WebAppTag           Setting
+	ClickhouseTag       Setting
+	OpensearchTag       Setting
+	GCPSecretAIToken    Setting
+	GCPCloudDNSZone     Setting
+	GCPNetwork          Setting
+	GCPServiceAccount   Setting
+	APICache            Setting
+
+	// file is the dotenv file the config was read from, if any, which is
+	// written back with the current values so that its comments, ordering and
+	// keys that are not settings are kept.
+	file *tools.EnvFile
 }
 
 // NewCloudDeploymentConfig creates a new CloudDeploymentConfig with defaults.
 func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error) {
-	effectivePath := defaultsCloudPath
+	effectivePath := DefaultsCloudPath
 	if configPath != "" {
 		effectivePath = configPath
 	}
 
-	env, err := tools.LoadEnvFromFile(effectivePath)
+	f, err := LoadConfigFile(effectivePath)
 	if err != nil {
 		return nil, err
 	}
+
+	c, err := NewCloudDeploymentConfigFromFile(f)
+	if err != nil {
+		return nil, err
+	}
+	SetSources(c, f.Map(), fileSource(configPath))
+	for _, w := range c.UnknownKeyWarnings() {
+		log.Printf("warning: %s: %s\n", effectivePath, w)
+	}
+	return c, nil
+}
+
+// NewCloudDeploymentConfigFromFile creates a new CloudDeploymentConfig from a
+// dotenv file, which is kept to write the config back.
+func NewCloudDeploymentConfigFromFile(f *tools.EnvFile) (*CloudDeploymentConfig, error) {
+	c, err := NewCloudDeploymentConfigFromEnv(f.Map())
+	if err != nil {
+		return nil, err
+	}
+	c.file = f
+	return c, nil
+}
+
+// UnknownKeyWarnings returns a warning for every key of the file the config was
+// read from that is not a setting but looks like a typo of one.
+func (c *CloudDeploymentConfig) UnknownKeyWarnings() []string {
+	if c.file == nil {
+		return nil
+	}
+	return unknownKeyWarnings(c, c.file.Keys())
+}
+
+// NewCloudDeploymentConfigFromEnv creates a new CloudDeploymentConfig from a map
+// of already parsed settings.
+func NewCloudDeploymentConfigFromEnv(env map[string]string) (*CloudDeploymentConfig, error) {
 	if env["OT_DEPLOYMENT_TYPE"] == "" {
 		return nil, fmt.Errorf("config file does not contain OT_DEPLOYMENT_TYPE setting")
 	}
@@ -72,6 +132,7 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 			Title:     "GCP Project",
 			Env:       "TF_VAR_OT_GCP_PROJECT",
 			Value:     env["TF_VAR_OT_GCP_PROJECT"],
+			Recreates: RecreatesDisks,
 			Validator: ValidateGCPProject,
 		},
 		GCPRegion: Setting{
@@ -80,9 +141,10 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 			Value: env["TF_VAR_OT_GCP_REGION"],
 		},
 		GCPZone: Setting{
-			Title: "GCP Zone",
-			Env:   "TF_VAR_OT_GCP_ZONE",
-			Value: env["TF_VAR_OT_GCP_ZONE"],
+			Title:     "GCP Zone",
+			Env:       "TF_VAR_OT_GCP_ZONE",
+			Value:     env["TF_VAR_OT_GCP_ZONE"],
+			Recreates: RecreatesDisks,
 		},
 		OpsURI: Setting{
 			Title:       "Ops URI",
@@ -94,16 +156,20 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 
 		// Second form: Deployment settings
 		DomainName: Setting{
-			Title:     "Domain name",
-			Env:       "TF_VAR_OT_DOMAIN_NAME",
-			Value:     env["TF_VAR_OT_DOMAIN_NAME"],
-			Validator: ValidateDomainName,
+			Title:       "Domain name",
+			Description: "The domain the deployment hostname is created under. Not used when the DNS mode is `none`.",
+			Env:         "TF_VAR_OT_DOMAIN_NAME",
+			Value:       env["TF_VAR_OT_DOMAIN_NAME"],
+			Pattern:     DomainNamePattern,
+			Validator:   ValidateDomainName,
 		},
 		SubdomainName: Setting{
 			Title:       "Subdomain name",
 			Description: "Subdomains should be only one level deep and contain only lowercase letters, numbers, and hyphens.",
 			Env:         "TF_VAR_OT_SUBDOMAIN_NAME",
-			Value:       tools.Either(env["TF_VAR_OT_SUBDOMAIN_NAME"], tools.RandomString(4)),
+			Value:       tools.Either(env["TF_VAR_OT_SUBDOMAIN_NAME"], tools.RandomName()),
+			Pattern:     SubdomainNamePattern,
+			Recreates:   RecreatesDisks,
 			Validator:   ValidateSubdomainName,
 		},
 		DaysToLive: Setting{
@@ -111,6 +177,7 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 			Description: "The deployment will be destroyed after this many days (0 for no expiry)",
 			Env:         "TF_VAR_OT_DAYS_TO_LIVE",
 			Value:       env["TF_VAR_OT_DAYS_TO_LIVE"],
+			Pattern:     NumberPattern,
 			Validator:   ValidateDaysToLive,
 		},
 		WebAppFlavor: Setting{
@@ -118,29 +185,113 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 			Description: "The flavor of the web application: `platform` or `ppp` partner preview (only available internally).",
 			Env:         "OT_WEBAPP_FLAVOR",
 			Value:       env["OT_WEBAPP_FLAVOR"],
+			Enum:        WebAppFlavors,
 			Validator:   ValidateWebAppFlavor,
 		},
+		DNSMode: Setting{
+			Title:       "DNS mode",
+			Description: "How the deployment hostname is resolved: `clouddns` creates a record in the Cloud DNS zone, `none` uses the IP address through sslip.io, and `external` prints the record to create in your own DNS.",
+			Env:         "TF_VAR_OT_DNS_MODE",
+			Value:       tools.Either(env["TF_VAR_OT_DNS_MODE"], "clouddns"),
+			Enum:        DNSModes,
+			Validator:   ValidateDNSMode,
+		},
 
-		// Third form: Data versions
+		// Third form: Access settings
+		AllowedSourceRanges: Setting{
+			Title:       "Allowed source ranges",
+			Description: "Comma separated list of IPv4 CIDR ranges allowed to reach the deployment, e.g. `192.0.2.0/24,198.51.100.7/32`. Use `0.0.0.0/0` to allow everyone.",
+			Env:         "TF_VAR_OT_ALLOWED_SOURCE_RANGES",
+			Value:       tools.Either(env["TF_VAR_OT_ALLOWED_SOURCE_RANGES"], "0.0.0.0/0"),
+			Validator:   ValidateSourceRanges,
+		},
+		AccessMode: Setting{
+			Title:       "Access mode",
+			Description: "Authentication required to access the deployment: `none`, HTTP `basic` auth, or an `oauth2` proxy. ppp deployments must either restrict the source ranges or require authentication.",
+			Env:         "TF_VAR_OT_ACCESS_MODE",
+			Value:       tools.Either(env["TF_VAR_OT_ACCESS_MODE"], "none"),
+			Enum:        AccessModes,
+		},
+		GCPSecretAccess: Setting{
+			Title:       "GCP access credentials secret",
+			Description: "The Google Cloud Secret Manager secret with an htpasswd file for `basic` access, or an oauth2-proxy env file for `oauth2` access. Not used with `none`.",
+			Env:         "TF_VAR_OT_GCP_SECRET_ACCESS",
+			Value:       env["TF_VAR_OT_GCP_SECRET_ACCESS"],
+		},
+
+		// Fourth form: Machine settings
+		MachineType: Setting{
+			Title:       "Machine type",
+			Description: "The Compute Engine machine type, e.g. `n1-standard-4`. Bigger machines are useful for benchmarking, smaller ones for quick demos.",
+			Env:         "TF_VAR_OT_MACHINE_TYPE",
+			Value:       tools.Either(env["TF_VAR_OT_MACHINE_TYPE"], "n1-standard-4"),
+		},
+		ProvisioningModel: Setting{
+			Title:       "Provisioning model",
+			Description: "`STANDARD` VMs, or cheaper `SPOT` VMs that can be preempted at any time. A preempted VM is stopped, and the platform runs again when it is started.",
+			Env:         "TF_VAR_OT_PROVISIONING_MODEL",
+			Value:       tools.Either(env["TF_VAR_OT_PROVISIONING_MODEL"], "STANDARD"),
+			Enum:        ProvisioningModels,
+			Recreates:   RecreatesVM,
+			Validator:   ValidateProvisioningModel,
+		},
+		Schedule: Setting{
+			Title:       "Schedule",
+			Description: "When the VM runs, as `daily` or `weekdays` followed by start and stop times and a time zone, e.g. `weekdays 08:00-20:00 Europe/London`. Leave empty to run all the time.",
+			Env:         "TF_VAR_OT_SCHEDULE",
+			Value:       env["TF_VAR_OT_SCHEDULE"],
+			Pattern:     SchedulePattern,
+			Validator:   ValidateSchedule,
+		},
+		BootImage: Setting{
+			Title:       "Boot image",
+			Description: "The Debian-based image for the boot disk, in the form project/family or project/image, e.g. `debian-cloud/debian-12`.",
+			Env:         "TF_VAR_OT_BOOT_IMAGE",
+			Value:       tools.Either(env["TF_VAR_OT_BOOT_IMAGE"], "debian-cloud/debian-12"),
+			Recreates:   RecreatesVM,
+			Validator:   ValidateGCPImage,
+		},
+		DataDiskType: Setting{
+			Title:       "Data disk type",
+			Description: "The disk type for the ClickHouse and OpenSearch data disks, e.g. `pd-balanced` or `pd-ssd`.",
+			Env:         "TF_VAR_OT_DATA_DISK_TYPE",
+			Value:       tools.Either(env["TF_VAR_OT_DATA_DISK_TYPE"], "pd-balanced"),
+			Recreates:   RecreatesDisks,
+		},
+		DataDiskExtraSize: Setting{
+			Title:       "Data disk extra size",
+			Description: "GB added to each data disk on top of the size of its snapshot.",
+			Env:         "TF_VAR_OT_DATA_DISK_EXTRA_SIZE",
+			Value:       tools.Either(env["TF_VAR_OT_DATA_DISK_EXTRA_SIZE"], "0"),
+			Pattern:     NumberPattern,
+			Validator:   ValidateDiskExtraSize,
+		},
+
+		// Fifth form: Data versions
 		Release: Setting{
 			Title:       "Data release",
 			Description: "The data release version, YY.MM. The API needs awareness of this to construct database namespace/index prefixes, e.g., `25.06`.",
 			Env:         "OT_RELEASE",
 			Value:       env["OT_RELEASE"],
+			Pattern:     ReleasePattern,
 			Validator:   ValidateRelease,
 		},
 		SnapshotCH: Setting{
-			Title: "ClickHouse data snapshot",
-			Env:   "TF_VAR_OT_SNAPSHOT_CH",
-			Value: env["TF_VAR_OT_SNAPSHOT_CH"],
+			Title:       "ClickHouse data snapshot",
+			Description: "The snapshot the ClickHouse data disk is created from. Snapshots of the release are listed with their creation date and size.",
+			Env:         "TF_VAR_OT_SNAPSHOT_CH",
+			Value:       env["TF_VAR_OT_SNAPSHOT_CH"],
+			Recreates:   RecreatesDisks,
 		},
 		SnapshotOS: Setting{
-			Title: "OpenSearch data snapshot",
-			Env:   "TF_VAR_OT_SNAPSHOT_OS",
-			Value: env["TF_VAR_OT_SNAPSHOT_OS"],
+			Title:       "OpenSearch data snapshot",
+			Description: "The snapshot the OpenSearch data disk is created from. Snapshots of the release are listed with their creation date and size.",
+			Env:         "TF_VAR_OT_SNAPSHOT_OS",
+			Value:       env["TF_VAR_OT_SNAPSHOT_OS"],
+			Recreates:   RecreatesDisks,
 		},
 
-		// Fourth form: Software versions
+		// Sixth form: Software versions
 		APIImage: Setting{
 			Title:     "API docker image name",
 			Env:       "OT_API_IMAGE",
@@ -190,7 +341,7 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 			Validator: ValidateNotEmpty,
 		},
 
-		// Fifth form: Additional settings
+		// Seventh form: Additional settings
 		GCPSecretAIToken: Setting{
 			Title:       "GCP AI API token secret",
 			Description: "The Google Cloud Secret Manager secret that contains the API token to use inside the AI API for the publication summarization feature.",
@@ -203,9 +354,10 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 			Value: env["TF_VAR_OT_GCP_CLOUD_DNS_ZONE"],
 		},
 		GCPNetwork: Setting{
-			Title: "GCP Network",
-			Env:   "TF_VAR_OT_GCP_NETWORK",
-			Value: env["TF_VAR_OT_GCP_NETWORK"],
+			Title:     "GCP Network",
+			Env:       "TF_VAR_OT_GCP_NETWORK",
+			Value:     env["TF_VAR_OT_GCP_NETWORK"],
+			Recreates: RecreatesVM,
 		},
 		GCPServiceAccount: Setting{
 			Title:       "GCP Service Account",
@@ -218,6 +370,7 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 			Description: "Whether the API should use caching (recommended) or not. Disable for development purposes.",
 			Env:         "PLATFORM_API_IGNORE_CACHE",
 			Value:       tools.Either(env["PLATFORM_API_IGNORE_CACHE"], "false"),
+			Enum:        []string{"true", "false"},
 		},
 	}
 
@@ -225,6 +378,12 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 	config.GCPRegion.Validator = ValidateGCPRegion(func() string { return config.GCPProject.Value })
 	config.GCPZone.Validator = ValidateGCPZone(func() string { return config.GCPProject.Value })
 
+	config.AccessMode.Validator = ValidateAccessMode(func() string { return config.WebAppFlavor.Value }, func() string { return config.AllowedSourceRanges.Value })
+	config.GCPSecretAccess.Validator = ValidateGCPAccessSecret(func() string { return config.AccessMode.Value }, func() string { return config.GCPProject.Value })
+
+	config.MachineType.Validator = ValidateGCPMachineType(func() string { return config.GCPProject.Value }, func() string { return config.GCPZone.Value })
+	config.DataDiskType.Validator = ValidateGCPDiskType(func() string { return config.GCPProject.Value }, func() string { return config.GCPZone.Value })
+
 	config.SnapshotCH.Validator = ValidateGCPSnapshot(func() string { return config.GCPProject.Value })
 	config.SnapshotOS.Validator = ValidateGCPSnapshot(func() string { return config.GCPProject.Value })
 
@@ -233,10 +392,15 @@ func NewCloudDeploymentConfig(configPath string) (*CloudDeploymentConfig, error)
 	config.WebAppTag.Validator = ValidateVersionTag(func() string { return config.WebAppImage.Value })
 
 	config.GCPSecretAIToken.Validator = ValidateGCPSecret(func() string { return config.GCPProject.Value })
-	config.GCPCloudDNSZone.Validator = ValidateGCPCloudDNSZone(func() string { return config.GCPProject.Value })
+	config.GCPCloudDNSZone.Validator = ValidateGCPCloudDNSZone(func() string { return config.DNSMode.Value }, func() string { return config.GCPProject.Value })
 	config.GCPNetwork.Validator = ValidateGCPNetwork(func() string { return config.WebAppFlavor.Value }, func() string { return config.GCPProject.Value })
 	config.GCPServiceAccount.Validator = ValidateGCPServiceAccount(func() string { return config.GCPProject.Value })
 
+	// Set rules that check settings against each other, and fill in the ones
+	// that can be derived.
+	config.addRules()
+	config.fillSnapshots("")
+
 	return config, nil
 }
 
@@ -256,6 +420,16 @@ func (c *CloudDeploymentConfig) Validate() error {
 	tools.AppendIfErr(&errs, c.SubdomainName.Validate())
 	tools.AppendIfErr(&errs, c.DaysToLive.Validate())
 	tools.AppendIfErr(&errs, c.WebAppFlavor.Validate())
+	tools.AppendIfErr(&errs, c.DNSMode.Validate())
+	tools.AppendIfErr(&errs, c.AllowedSourceRanges.Validate())
+	tools.AppendIfErr(&errs, c.AccessMode.Validate())
+	tools.AppendIfErr(&errs, c.GCPSecretAccess.Validate())
+	tools.AppendIfErr(&errs, c.MachineType.Validate())
+	tools.AppendIfErr(&errs, c.ProvisioningModel.Validate())
+	tools.AppendIfErr(&errs, c.Schedule.Validate())
+	tools.AppendIfErr(&errs, c.BootImage.Validate())
+	tools.AppendIfErr(&errs, c.DataDiskType.Validate())
+	tools.AppendIfErr(&errs, c.DataDiskExtraSize.Validate())
 	tools.AppendIfErr(&errs, c.Release.Validate())
 	tools.AppendIfErr(&errs, c.SnapshotCH.Validate())
 	tools.AppendIfErr(&errs, c.SnapshotOS.Validate())
@@ -287,9 +461,27 @@ func (c *CloudDeploymentConfig) ReplaceFromEnv() {
 	c.SubdomainName.ReplaceFromEnv()
 	c.DaysToLive.ReplaceFromEnv()
 	c.WebAppFlavor.ReplaceFromEnv()
+	c.DNSMode.ReplaceFromEnv()
+	c.AllowedSourceRanges.ReplaceFromEnv()
+	c.AccessMode.ReplaceFromEnv()
+	c.GCPSecretAccess.ReplaceFromEnv()
+	c.MachineType.ReplaceFromEnv()
+	c.ProvisioningModel.ReplaceFromEnv()
+	c.Schedule.ReplaceFromEnv()
+	c.BootImage.ReplaceFromEnv()
+	c.DataDiskType.ReplaceFromEnv()
+	c.DataDiskExtraSize.ReplaceFromEnv()
+	previousRelease := c.Release.Value
 	c.Release.ReplaceFromEnv()
 	c.SnapshotCH.ReplaceFromEnv()
 	c.SnapshotOS.ReplaceFromEnv()
+	// Overriding the release alone also moves the snapshots to it, as long as
+	// they follow the naming convention.
+	_, snapshotCHSet := os.LookupEnv(c.SnapshotCH.Env)
+	_, snapshotOSSet := os.LookupEnv(c.SnapshotOS.Env)
+	if !snapshotCHSet && !snapshotOSSet {
+		c.fillSnapshots(previousRelease)
+	}
 	c.APIImage.ReplaceFromEnv()
 	c.APITag.ReplaceFromEnv()
 	c.APIAIImage.ReplaceFromEnv()
@@ -305,8 +497,20 @@ func (c *CloudDeploymentConfig) ReplaceFromEnv() {
 	c.APICache.ReplaceFromEnv()
 }
 
-// ToString returns a string representation of the CloudDeploymentConfig.
+// ToString returns a string representation of the CloudDeploymentConfig, as a
+// dotenv file. A config read from a file is written as that file with the
+// current values, keeping its comments, ordering and keys that are not settings.
 func (c *CloudDeploymentConfig) ToString() string {
+	if c.file != nil {
+		f := c.file.Clone()
+		for _, s := range c.GetSettings() {
+			if !s.Secret {
+				f.Set(s.Env, s.Value)
+			}
+		}
+		return f.String()
+	}
+
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("# Open Targets cloud deployment config for https://%s.%s\n", c.SubdomainName.Value, c.DomainName.Value))
 	sb.WriteString(c.DeploymentType.ToString())
@@ -320,6 +524,18 @@ func (c *CloudDeploymentConfig) ToString() string {
 	sb.WriteString(c.SubdomainName.ToString())
 	sb.WriteString(c.DaysToLive.ToString())
 	sb.WriteString(c.WebAppFlavor.ToString())
+	sb.WriteString(c.DNSMode.ToString())
+	sb.WriteString("\n# Access settings\n")
+	sb.WriteString(c.AllowedSourceRanges.ToString())
+	sb.WriteString(c.AccessMode.ToString())
+	sb.WriteString(c.GCPSecretAccess.ToString())
+	sb.WriteString("\n# Machine settings\n")
+	sb.WriteString(c.MachineType.ToString())
+	sb.WriteString(c.ProvisioningModel.ToString())
+	sb.WriteString(c.Schedule.ToString())
+	sb.WriteString(c.BootImage.ToString())
+	sb.WriteString(c.DataDiskType.ToString())
+	sb.WriteString(c.DataDiskExtraSize.ToString())
 	sb.WriteString("\n# Data versions\n")
 	sb.WriteString(c.Release.ToString())
 	sb.WriteString(c.SnapshotCH.ToString())
@@ -342,6 +558,92 @@ func (c *CloudDeploymentConfig) ToString() string {
 	return sb.String()
 }
 
+// GetSettings returns all the Settings in the CloudDeploymentConfig.
+func (c *CloudDeploymentConfig) GetSettings() []*Setting {
+	return []*Setting{
+		&c.DeploymentType,
+		&c.GCPProject,
+		&c.GCPRegion,
+		&c.GCPZone,
+		&c.OpsURI,
+		&c.DomainName,
+		&c.SubdomainName,
+		&c.DaysToLive,
+		&c.WebAppFlavor,
+		&c.DNSMode,
+		&c.AllowedSourceRanges,
+		&c.AccessMode,
+		&c.GCPSecretAccess,
+		&c.MachineType,
+		&c.ProvisioningModel,
+		&c.Schedule,
+		&c.BootImage,
+		&c.DataDiskType,
+		&c.DataDiskExtraSize,
+		&c.Release,
+		&c.SnapshotCH,
+		&c.SnapshotOS,
+		&c.APIImage,
+		&c.APITag,
+		&c.APIAIImage,
+		&c.APIAITag,
+		&c.WebAppImage,
+		&c.WebAppTag,
+		&c.ClickhouseTag,
+		&c.OpensearchTag,
+		&c.GCPSecretAIToken,
+		&c.GCPCloudDNSZone,
+		&c.GCPNetwork,
+		&c.GCPServiceAccount,
+		&c.APICache,
+	}
+}
+
+// SettingSection is a group of settings, as shown in a page of the form. Key
+// names the section in structured config files.
+type SettingSection struct {
+	Key      string
+	Title    string
+	Settings []*Setting
+}
+
+// Sections returns the Settings in the CloudDeploymentConfig grouped as in the
+// form.
+func (c *CloudDeploymentConfig) Sections() []SettingSection {
+	return []SettingSection{
+		{"gcp", "GCP global settings", []*Setting{&c.GCPProject, &c.GCPRegion, &c.GCPZone, &c.OpsURI}},
+		{"deployment", "Deployment settings", []*Setting{&c.DomainName, &c.SubdomainName, &c.DaysToLive, &c.WebAppFlavor, &c.DNSMode}},
+		{"access", "Access settings", []*Setting{&c.AllowedSourceRanges, &c.AccessMode, &c.GCPSecretAccess}},
+		{"machine", "Machine settings", []*Setting{&c.MachineType, &c.ProvisioningModel, &c.Schedule, &c.BootImage, &c.DataDiskType, &c.DataDiskExtraSize}},
+		{"data", "Data versions", []*Setting{&c.Release, &c.SnapshotCH, &c.SnapshotOS}},
+		{"software", "Software versions", []*Setting{&c.APIImage, &c.APITag, &c.APIAIImage, &c.APIAITag, &c.WebAppImage, &c.WebAppTag, &c.ClickhouseTag, &c.OpensearchTag}},
+		{"additional", "Additional settings", []*Setting{&c.GCPSecretAIToken, &c.GCPCloudDNSZone, &c.GCPNetwork, &c.GCPServiceAccount, &c.APICache}},
+	}
+}
+
+// OpsLabel returns the value of the `ops` label terraform sets on the resources
+// of the deployments stored in an ops URI, which tells them apart from those of
+// other ops URIs in the same project. GCS URIs are not valid label values, so
+// the label is a hash of the URI.
+func OpsLabel(opsURI string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(opsURI, "/")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// GetTerraformVars returns the settings that are terraform input variables,
+// keyed by variable name, along with the ops label of the deployment.
+func (c *CloudDeploymentConfig) GetTerraformVars() map[string]string {
+	vars := map[string]string{
+		"OT_OPS_LABEL": OpsLabel(c.OpsURI.Value),
+	}
+	for _, s := range c.GetSettings() {
+		if name, ok := strings.CutPrefix(s.Env, terraformVarPrefix); ok {
+			vars[name] = s.Value
+		}
+	}
+	return vars
+}
+
 // GetSecretFields returns a slice of Settings that are secrets in the CloudDeploymentConfig.
 func (c *CloudDeploymentConfig) GetSecretFields() []Setting {
 	secrets := []Setting{}
@@ -371,12 +673,54 @@ func CloudDeploymentForm(c *CloudDeploymentConfig) *huh.Form {
 				Description(c.WebAppFlavor.Description).
 				Value(&c.WebAppFlavor.Value).
 				Validate(c.WebAppFlavor.Validator),
+			huh.NewSelect[string]().
+				Options(
+					huh.Option[string]{Value: "clouddns", Key: "cloud dns"},
+					huh.Option[string]{Value: "none", Key: "none (sslip.io)"},
+					huh.Option[string]{Value: "external", Key: "external"},
+				).
+				Title(c.DNSMode.Title).
+				Description(c.DNSMode.Description).
+				Value(&c.DNSMode.Value).
+				Validate(c.DNSMode.Validator),
 		).
 			Title("Deployment settings"),
+		huh.NewGroup(
+			c.AllowedSourceRanges.Input(),
+			huh.NewSelect[string]().
+				Options(
+					huh.Option[string]{Value: "none", Key: "none"},
+					huh.Option[string]{Value: "basic", Key: "basic auth"},
+					huh.Option[string]{Value: "oauth2", Key: "oauth2 proxy"},
+				).
+				Title(c.AccessMode.Title).
+				Description(c.AccessMode.Description).
+				Value(&c.AccessMode.Value).
+				Validate(c.AccessMode.Validator),
+			c.GCPSecretAccess.Input(),
+		).
+			Title("Access settings"),
+		huh.NewGroup(
+			c.MachineType.Input(),
+			huh.NewSelect[string]().
+				Options(
+					huh.Option[string]{Value: "STANDARD", Key: "standard"},
+					huh.Option[string]{Value: "SPOT", Key: "spot"},
+				).
+				Title(c.ProvisioningModel.Title).
+				Description(c.ProvisioningModel.Description).
+				Value(&c.ProvisioningModel.Value).
+				Validate(c.ProvisioningModel.Validator),
+			c.Schedule.Input(),
+			c.BootImage.Input(),
+			c.DataDiskType.Input(),
+			c.DataDiskExtraSize.Input(),
+		).
+			Title("Machine settings"),
 		huh.NewGroup(
 			c.Release.Input(),
-			c.SnapshotCH.Input(),
-			c.SnapshotOS.Input(),
+			snapshotSelect(&c.SnapshotCH, EngineClickHouse, c),
+			snapshotSelect(&c.SnapshotOS, EngineOpenSearch, c),
 		).
 			Title("Data versions"),
 		huh.NewGroup(
@@ -408,3 +752,48 @@ func CloudDeploymentForm(c *CloudDeploymentConfig) *huh.Form {
 			Title("Additional settings"),
 	)
 }
+
+// snapshotSelect creates a select for a snapshot setting, listing the snapshots
+// of the engine for the release in the form. The current value is always an
+// option, so snapshots outside of the catalog can still be used. If the
+// snapshots cannot be listed, the error is shown in the description.
+func snapshotSelect(s *Setting, engine string, c *CloudDeploymentConfig) *huh.Select[string] {
+	// The options and the description are both updated when the release
+	// changes, and share a single listing per release.
+	var listedProject, listedRelease string
+	var snapshots []Snapshot
+	var listErr error
+	listed := false
+	list := func() {
+		if listed && listedProject == c.GCPProject.Value && listedRelease == c.Release.Value {
+			return
+		}
+		listed, listedProject, listedRelease = true, c.GCPProject.Value, c.Release.Value
+		snapshots, listErr = ListSnapshots(c.GCPProject.Value, engine, c.Release.Value)
+	}
+
+	return huh.NewSelect[string]().
+		OptionsFunc(func() []huh.Option[string] {
+			list()
+			var options []huh.Option[string]
+			current := false
+			for _, snapshot := range snapshots {
+				options = append(options, huh.NewOption(snapshot.Describe(), snapshot.Name))
+				current = current || snapshot.Name == s.Value
+			}
+			if !current && s.Value != "" {
+				options = append(options, huh.NewOption(s.Value, s.Value))
+			}
+			return options
+		}, &c.Release.Value).
+		Title(s.Title).
+		DescriptionFunc(func() string {
+			list()
+			if listErr != nil {
+				return fmt.Sprintf("%s\nThe snapshots of project %s cannot be listed: %v", s.Description, c.GCPProject.Value, listErr)
+			}
+			return s.Description
+		}, &c.Release.Value).
+		Value(&s.Value).
+		Validate(s.ValidateWithSpinner())
+}
@@ -22,8 +22,33 @@ type DeploymentConfig interface {
 	ToString() string
 	// GetSecretFields returns a slice of Settings that are secrets.
 	GetSecretFields() []Setting
+	// GetSettings returns all the Settings in the deployment configuration.
+	GetSettings() []*Setting
 }
 
+// Env returns the non-secret settings of a deployment configuration as a map
+// of environment variables, to be passed explicitly to subprocesses.
+func Env(c DeploymentConfig) map[string]string {
+	env := map[string]string{}
+	for _, s := range c.GetSettings() {
+		if !s.Secret {
+			env[s.Env] = s.Value
+		}
+	}
+	return env
+}
+
+// What changing a setting of a deployment makes terraform replace, beyond
+// updating the VM in place.
+const (
+	// RecreatesVM marks settings whose change replaces the VM, keeping the data
+	// disks.
+	RecreatesVM = "vm"
+	// RecreatesDisks marks settings whose change replaces the data disks, and
+	// with them the data on them.
+	RecreatesDisks = "data disks"
+)
+
 // Setting represents a configuration setting.
 type Setting struct {
 	Title          string
@@ -34,11 +59,22 @@ type Setting struct {
 	SecretFilename string
 	Validator      func(value string) error
 	ValidatedValue string
+	Rules          []Rule
+	// Recreates is what a change of the setting replaces in a deployment,
+	// RecreatesVM or RecreatesDisks, or empty if it is updated in place.
+	Recreates string
+	// Source is where the value comes from, one of the Source constants.
+	Source string
+	// Pattern and Enum describe the valid values of the setting in the config
+	// schema, if they can be told without looking at other settings or GCP.
+	Pattern string
+	Enum    []string
 }
 
-// Validate checks the value of the Setting using the provided validator function.
+// Validate checks the value of the Setting using the provided validator function
+// and the rules attached to it.
 func (s *Setting) Validate() error {
-	if s.Validator == nil {
+	if s.Validator == nil && len(s.Rules) == 0 {
 		return nil
 	}
 	err := s.ValidateWithSpinner()(s.Value)
@@ -48,10 +84,20 @@ func (s *Setting) Validate() error {
 	return nil
 }
 
-// ReplaceFromEnv sets the value of the Setting from the environment.
+// Key returns the name of the Setting in structured config files: its env name
+// in lowercase, without the terraform and Open Targets prefixes.
+func (s *Setting) Key() string {
+	key := strings.TrimPrefix(s.Env, terraformVarPrefix)
+	key = strings.TrimPrefix(key, "OT_")
+	return strings.ToLower(key)
+}
+
+// ReplaceFromEnv sets the value of the Setting from the environment, and marks
+// it as coming from it if it changes.
 func (s *Setting) ReplaceFromEnv() {
-	if newValue, exists := os.LookupEnv(s.Env); exists {
+	if newValue, exists := os.LookupEnv(s.Env); exists && newValue != s.Value {
 		s.Value = newValue
+		s.Source = SourceEnv
 	}
 }
 
@@ -60,21 +106,27 @@ func (s *Setting) ToString() string {
 	if s.Secret {
 		return fmt.Sprintf("# %s is a secret located at ./%s\n", s.Env, s.SecretFilename)
 	}
-	return fmt.Sprintf("%s=\"%s\"\n", s.Env, s.Value)
+	return fmt.Sprintf("%s=%s\n", s.Env, tools.QuoteEnvValue(s.Value))
 }
 
 // ValidateWithSpinner returns a validation function that uses a spinner to indicate progress.
+// The result of the validator is cached for the last valid value, but rules depend
+// on other settings, so they always run.
 func (s *Setting) ValidateWithSpinner() func(v string) error {
 	return func(v string) error {
-		if s.ValidatedValue == v || s.Validator == nil {
+		if (s.ValidatedValue == v || s.Validator == nil) && len(s.Rules) == 0 {
 			return nil
 		}
 		var err error
 		a := func() {
-			err = s.Validator(v)
-			if err == nil {
+			if s.Validator != nil && s.ValidatedValue != v {
+				err = s.Validator(v)
+				if err != nil {
+					return
+				}
 				s.ValidatedValue = v
 			}
+			err = s.checkRules(v)
 		}
 		tools.RunWithSpinner(fmt.Sprintf("checking %s", strings.ToLower(s.Title)), a)
 		if err != nil {
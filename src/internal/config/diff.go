@@ -0,0 +1,98 @@
+package config
+
+import "strings"
+
+// SettingChange is a setting whose value differs between two configs.
+type SettingChange struct {
+	Setting *Setting
+	Old     string
+	New     string
+}
+
+// SectionChanges are the changes between two configs in a section of the form.
+type SectionChanges struct {
+	Title   string
+	Changes []SettingChange
+}
+
+// Diff compares two cloud deployment configs setting by setting, and returns
+// the changes from a to b grouped by section. Sections without changes are left
+// out.
+func Diff(a, b *CloudDeploymentConfig) []SectionChanges {
+	var diff []SectionChanges
+	sectionsA, sectionsB := a.Sections(), b.Sections()
+	for i, section := range sectionsB {
+		changes := SectionChanges{Title: section.Title}
+		for j, s := range section.Settings {
+			if old := sectionsA[i].Settings[j].Value; old != s.Value {
+				changes.Changes = append(changes.Changes, SettingChange{Setting: s, Old: old, New: s.Value})
+			}
+		}
+		if len(changes.Changes) > 0 {
+			diff = append(diff, changes)
+		}
+	}
+	return diff
+}
+
+// ExtraChange is a key of a config file that is not a setting, such as an image
+// tag, whose value differs between two configs. A key missing from a config has
+// an empty value.
+type ExtraChange struct {
+	Key string
+	Old string
+	New string
+}
+
+// DiffExtra compares the keys of the files of two cloud deployment configs that
+// are not settings, and returns the changes from a to b, in the order of the
+// file of b and then of a.
+func DiffExtra(a, b *CloudDeploymentConfig) []ExtraChange {
+	extraA, extraB := a.extra(), b.extra()
+	var changes []ExtraChange
+	seen := map[string]bool{}
+	for _, key := range append(b.extraKeys(), a.extraKeys()...) {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		oldValue, inA := extraA[key]
+		newValue, inB := extraB[key]
+		if oldValue != newValue || inA != inB {
+			changes = append(changes, ExtraChange{Key: key, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+// Recreates returns what applying the changes replaces in a deployment, with
+// RecreatesDisks taking precedence over RecreatesVM, or empty if everything is
+// updated in place.
+func Recreates(diff []SectionChanges) string {
+	recreates := ""
+	for _, section := range diff {
+		for _, ch := range section.Changes {
+			switch ch.Setting.Recreates {
+			case RecreatesDisks:
+				return RecreatesDisks
+			case RecreatesVM:
+				recreates = RecreatesVM
+			}
+		}
+	}
+	return recreates
+}
+
+// NeedsTerraform returns true if any of the changes is to a terraform variable,
+// so applying them takes a terraform run. Other settings are picked up by the
+// config watcher on the VM.
+func NeedsTerraform(diff []SectionChanges) bool {
+	for _, section := range diff {
+		for _, ch := range section.Changes {
+			if strings.HasPrefix(ch.Setting.Env, terraformVarPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+func TestDiff(t *testing.T) {
+	base := map[string]string{
+		"OT_DEPLOYMENT_TYPE":       "cloud",
+		"TF_VAR_OT_GCP_PROJECT":    "my-project",
+		"TF_VAR_OT_SUBDOMAIN_NAME": "dev",
+		"OT_RELEASE":               "25.09",
+		"TF_VAR_OT_SNAPSHOT_CH":    "custom-ch",
+		"TF_VAR_OT_SNAPSHOT_OS":    "custom-os",
+	}
+	tests := []struct {
+		name          string
+		changes       map[string]string
+		wantSections  []string
+		wantRecreates string
+		wantTerraform bool
+	}{
+		{"no changes", nil, nil, "", false},
+		{"gcp", map[string]string{"OT_OPS_URI": "gs://other/ops"}, []string{"GCP global settings"}, "", false},
+		{"deployment", map[string]string{"TF_VAR_OT_DAYS_TO_LIVE": "3"}, []string{"Deployment settings"}, "", true},
+		{"deployment without terraform", map[string]string{"OT_WEBAPP_FLAVOR": "ppp"}, []string{"Deployment settings"}, "", false},
+		{"access", map[string]string{"TF_VAR_OT_ACCESS_MODE": "basic"}, []string{"Access settings"}, "", true},
+		{"machine", map[string]string{"TF_VAR_OT_MACHINE_TYPE": "n1-standard-8"}, []string{"Machine settings"}, "", true},
+		{"machine recreating the vm", map[string]string{"TF_VAR_OT_BOOT_IMAGE": "debian-12"}, []string{"Machine settings"}, RecreatesVM, true},
+		{"data", map[string]string{"TF_VAR_OT_SNAPSHOT_CH": "other-ch"}, []string{"Data versions"}, RecreatesDisks, true},
+		{"data without terraform", map[string]string{"OT_RELEASE": "25.12"}, []string{"Data versions"}, "", false},
+		{"software", map[string]string{"OT_API_TAG": "1.2.3"}, []string{"Software versions"}, "", false},
+		{"additional", map[string]string{"PLATFORM_API_IGNORE_CACHE": "true"}, []string{"Additional settings"}, "", false},
+		{"additional with terraform", map[string]string{"TF_VAR_OT_GCP_NETWORK": "other"}, []string{"Additional settings"}, RecreatesVM, true},
+		{
+			"disks over vm",
+			map[string]string{"TF_VAR_OT_GCP_NETWORK": "other", "TF_VAR_OT_GCP_ZONE": "europe-west1-b", "OT_API_TAG": "1.2.3"},
+			[]string{"GCP global settings", "Software versions", "Additional settings"},
+			RecreatesDisks,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := maps.Clone(base)
+			maps.Copy(env, tt.changes)
+			a, err := NewCloudDeploymentConfigFromEnv(base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewCloudDeploymentConfigFromEnv(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diff := Diff(a, b)
+			var sections []string
+			changed := 0
+			for _, section := range diff {
+				sections = append(sections, section.Title)
+				for _, ch := range section.Changes {
+					changed++
+					if want, ok := tt.changes[ch.Setting.Env]; !ok || ch.New != want {
+						t.Errorf("change of %s to %q, want %q", ch.Setting.Env, ch.New, want)
+					}
+				}
+			}
+			if !slices.Equal(sections, tt.wantSections) {
+				t.Errorf("Diff() sections = %v, want %v", sections, tt.wantSections)
+			}
+			if changed != len(tt.changes) {
+				t.Errorf("Diff() has %d changes, want %d", changed, len(tt.changes))
+			}
+			if got := Recreates(diff); got != tt.wantRecreates {
+				t.Errorf("Recreates() = %q, want %q", got, tt.wantRecreates)
+			}
+			if got := NeedsTerraform(diff); got != tt.wantTerraform {
+				t.Errorf("NeedsTerraform() = %v, want %v", got, tt.wantTerraform)
+			}
+		})
+	}
+}
+
+func TestDiffExtra(t *testing.T) {
+	load := func(content string) *CloudDeploymentConfig {
+		t.Helper()
+		f, err := tools.ParseEnvFile(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := NewCloudDeploymentConfigFromFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	a := load("OT_DEPLOYMENT_TYPE=cloud\nTF_VAR_OT_SUBDOMAIN_NAME=dev\nOT_CUSTOM_TAG=1.0\nOT_KEPT=x\nOT_REMOVED=y\n")
+	b := load("OT_DEPLOYMENT_TYPE=cloud\nTF_VAR_OT_SUBDOMAIN_NAME=other\nOT_ADDED=z\nOT_CUSTOM_TAG=1.1\nOT_KEPT=x\n")
+	want := []ExtraChange{
+		{Key: "OT_ADDED", Old: "", New: "z"},
+		{Key: "OT_CUSTOM_TAG", Old: "1.0", New: "1.1"},
+		{Key: "OT_REMOVED", Old: "y", New: ""},
+	}
+	if got := DiffExtra(a, b); !slices.Equal(got, want) {
+		t.Errorf("DiffExtra() = %v, want %v", got, want)
+	}
+	if got := DiffExtra(a, a); len(got) != 0 {
+		t.Errorf("DiffExtra() of a config with itself = %v, want none", got)
+	}
+}
@@ -141,6 +141,8 @@ func NewLocalDeploymentConfig(configPath string) (*LocalDeploymentConfig, error)
 	config.APIAITag.Validator = ValidateVersionTag(func() string { return config.APIAIImage.Value })
 	config.WebAppTag.Validator = ValidateVersionTag(func() string { return config.WebAppImage.Value })
 
+	SetSources(config, env, fileSource(configPath))
+
 	return config, nil
 }
 
@@ -213,6 +215,25 @@ func (c *LocalDeploymentConfig) ToString() string {
 	return sb.String()
 }
 
+// GetSettings returns all the Settings in the LocalDeploymentConfig.
+func (c *LocalDeploymentConfig) GetSettings() []*Setting {
+	return []*Setting{
+		&c.DeploymentType,
+		&c.Release,
+		&c.ReleaseURL,
+		&c.APIImage,
+		&c.APITag,
+		&c.APIAIImage,
+		&c.APIAITag,
+		&c.WebAppImage,
+		&c.WebAppTag,
+		&c.ClickhouseTag,
+		&c.OpensearchTag,
+		&c.APIAIToken,
+		&c.APICache,
+	}
+}
+
 // GetSecretFields returns a slice of Settings that are secrets in the LocalDeploymentConfig.
 func (c *LocalDeploymentConfig) GetSecretFields() []Setting {
 	secrets := []Setting{
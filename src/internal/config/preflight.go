@@ -0,0 +1,410 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	admin "cloud.google.com/go/iam/admin/apiv1"
+	"cloud.google.com/go/iam/apiv1/iampb"
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	"cloud.google.com/go/storage"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bootDiskSizeGB is the size of the boot disk of the deployment VMs, which is
+// always a pd-ssd disk (see main.tf).
+const bootDiskSizeGB = 20
+
+// Preflight checks that the caller is allowed to create the resources of a
+// deployment, and that there is enough regional quota left for them. It is
+// meant to run after Validate, so the settings are known to be valid, and
+// reports every problem found rather than the first one.
+func (c *CloudDeploymentConfig) Preflight() error {
+	var errs []error
+
+	tools.AppendIfErr(&errs, c.checkProjectPermissions())
+	tools.AppendIfErr(&errs, c.checkZone())
+	tools.AppendIfErr(&errs, c.checkResourcePermissions())
+	tools.AppendIfErr(&errs, c.checkQuota())
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// projectPermissions returns the permissions needed on the project to create
+// and update the resources in main.tf, including setting the labels reap finds
+// them by.
+//
+// Zonal resources such as the VM and its disks are covered by these as well:
+// IAM policies cannot be set on a zone, only on the project and above or on
+// resources that already exist, so what is granted on the project is what the
+// caller can do in each of its zones. What can differ by zone is whether it is
+// usable at all, which checkZone tests.
+func (c *CloudDeploymentConfig) projectPermissions() []string {
+	permissions := []string{
+		"compute.disks.create",
+		"compute.disks.delete",
+		"compute.disks.get",
+		"compute.disks.resize",
+		"compute.disks.setLabels",
+		"compute.disks.use",
+		"compute.firewalls.create",
+		"compute.firewalls.delete",
+		"compute.firewalls.get",
+		"compute.firewalls.update",
+		"compute.instances.create",
+		"compute.instances.delete",
+		"compute.instances.get",
+		"compute.instances.setMachineType",
+		"compute.instances.setLabels",
+		"compute.instances.setMetadata",
+		"compute.instances.setServiceAccount",
+		"compute.instances.setTags",
+		"compute.instances.start",
+		"compute.instances.stop",
+		"compute.networks.updatePolicy",
+		"compute.snapshots.get",
+	}
+
+	switch c.DNSMode.Value {
+	case "clouddns":
+		permissions = append(permissions,
+			"dns.changes.create",
+			"dns.resourceRecordSets.create",
+			"dns.resourceRecordSets.delete",
+			"dns.resourceRecordSets.update",
+		)
+	default:
+		permissions = append(permissions,
+			"compute.addresses.create",
+			"compute.addresses.delete",
+			"compute.addresses.get",
+			"compute.addresses.setLabels",
+			"compute.addresses.use",
+		)
+	}
+
+	if c.Schedule.Value != "" {
+		permissions = append(permissions,
+			"compute.instances.addResourcePolicies",
+			"compute.instances.removeResourcePolicies",
+			"compute.resourcePolicies.create",
+			"compute.resourcePolicies.delete",
+			"compute.resourcePolicies.get",
+			"compute.resourcePolicies.use",
+		)
+	}
+
+	return permissions
+}
+
+// checkProjectPermissions tests the project level permissions of the caller.
+func (c *CloudDeploymentConfig) checkProjectPermissions() error {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	client, err := resourcemanager.NewProjectsClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	wanted := c.projectPermissions()
+	resp, err := client.TestIamPermissions(ctx, &iampb.TestIamPermissionsRequest{
+		Resource:    "projects/" + c.GCPProject.Value,
+		Permissions: wanted,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to test permissions on project %s: %w", c.GCPProject.Value, err)
+	}
+
+	return missingPermissions("project "+c.GCPProject.Value, wanted, resp.GetPermissions())
+}
+
+// checkZone checks that the zone of a deployment is up and in its region, so the
+// zonal VM and disks can be created in it.
+func (c *CloudDeploymentConfig) checkZone() error {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	zones, err := compute.NewZonesRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer zones.Close()
+
+	zone, err := zones.Get(ctx, &computepb.GetZoneRequest{
+		Project: c.GCPProject.Value,
+		Zone:    c.GCPZone.Value,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to look up zone %s in project %s: %w", c.GCPZone.Value, c.GCPProject.Value, err)
+	}
+
+	if zone.GetStatus() != "UP" {
+		return fmt.Errorf("zone %s is %s", c.GCPZone.Value, strings.ToLower(zone.GetStatus()))
+	}
+	if state := zone.GetDeprecated().GetState(); state != "" && state != "ACTIVE" {
+		return fmt.Errorf("zone %s is %s", c.GCPZone.Value, strings.ToLower(state))
+	}
+	if region := zone.GetRegion(); region[strings.LastIndex(region, "/")+1:] != c.GCPRegion.Value {
+		return fmt.Errorf("zone %s is not in region %s", c.GCPZone.Value, c.GCPRegion.Value)
+	}
+	return nil
+}
+
+// checkResourcePermissions tests the permissions of the caller on the existing
+// resources a deployment uses.
+func (c *CloudDeploymentConfig) checkResourcePermissions() error {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	var errs []error
+
+	// Subnetwork the VM is attached to, with an external IP address.
+	subnetworks, err := compute.NewSubnetworksRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer subnetworks.Close()
+
+	wanted := []string{"compute.subnetworks.use", "compute.subnetworks.useExternalIp"}
+	subnetResp, err := subnetworks.TestIamPermissions(ctx, &computepb.TestIamPermissionsSubnetworkRequest{
+		Project:                        c.GCPProject.Value,
+		Region:                         c.GCPRegion.Value,
+		Resource:                       c.GCPNetwork.Value,
+		TestPermissionsRequestResource: &computepb.TestPermissionsRequest{Permissions: wanted},
+	})
+	if err != nil {
+		errs = append(errs, permissionTestError("subnetwork "+c.GCPNetwork.Value, err))
+	} else {
+		tools.AppendIfErr(&errs, missingPermissions("subnetwork "+c.GCPNetwork.Value, wanted, subnetResp.GetPermissions()))
+	}
+
+	// Snapshots the data disks are created from.
+	snapshots, err := compute.NewSnapshotsRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer snapshots.Close()
+
+	wanted = []string{"compute.snapshots.useReadOnly"}
+	for _, snapshot := range []string{c.SnapshotCH.Value, c.SnapshotOS.Value} {
+		snapshotResp, err := snapshots.TestIamPermissions(ctx, &computepb.TestIamPermissionsSnapshotRequest{
+			Project:                        c.GCPProject.Value,
+			Resource:                       snapshot,
+			TestPermissionsRequestResource: &computepb.TestPermissionsRequest{Permissions: wanted},
+		})
+		if err != nil {
+			errs = append(errs, permissionTestError("snapshot "+snapshot, err))
+			continue
+		}
+		tools.AppendIfErr(&errs, missingPermissions("snapshot "+snapshot, wanted, snapshotResp.GetPermissions()))
+	}
+
+	// Service account the VM runs as.
+	iam, err := admin.NewIamClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer iam.Close()
+
+	wanted = []string{"iam.serviceAccounts.actAs"}
+	saResp, err := iam.TestIamPermissions(ctx, &iampb.TestIamPermissionsRequest{
+		Resource:    fmt.Sprintf("projects/%s/serviceAccounts/%s", c.GCPProject.Value, c.GCPServiceAccount.Value),
+		Permissions: wanted,
+	})
+	if err != nil {
+		errs = append(errs, permissionTestError("service account "+c.GCPServiceAccount.Value, err))
+	} else {
+		tools.AppendIfErr(&errs, missingPermissions("service account "+c.GCPServiceAccount.Value, wanted, saResp.GetPermissions()))
+	}
+
+	// Ops bucket holding the terraform state and the deployment config.
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer storageClient.Close()
+
+	bucket := strings.SplitN(strings.TrimPrefix(c.OpsURI.Value, "gs://"), "/", 2)[0]
+	wanted = []string{"storage.objects.create", "storage.objects.delete", "storage.objects.get", "storage.objects.list"}
+	granted, err := storageClient.Bucket(bucket).IAM().TestPermissions(ctx, wanted)
+	if err != nil {
+		errs = append(errs, permissionTestError("bucket "+bucket, err))
+	} else {
+		tools.AppendIfErr(&errs, missingPermissions("bucket "+bucket, wanted, granted))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// checkQuota checks that the region has quota left for the VM and disks of a
+// new deployment. Existing deployments are already counted in the usage, so
+// they are not checked.
+func (c *CloudDeploymentConfig) checkQuota() error {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	instances, err := compute.NewInstancesRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer instances.Close()
+
+	_, err = instances.Get(ctx, &computepb.GetInstanceRequest{
+		Project:  c.GCPProject.Value,
+		Zone:     c.GCPZone.Value,
+		Instance: "devinstance-" + c.SubdomainName.Value,
+	})
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return fmt.Errorf("unable to look up instance for deployment %s: %w", c.SubdomainName.Value, err)
+	}
+
+	machineTypes, err := compute.NewMachineTypesRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer machineTypes.Close()
+
+	machineType, err := machineTypes.Get(ctx, &computepb.GetMachineTypeRequest{
+		Project:     c.GCPProject.Value,
+		Zone:        c.GCPZone.Value,
+		MachineType: c.MachineType.Value,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to look up machine type %s: %w", c.MachineType.Value, err)
+	}
+
+	snapshots, err := compute.NewSnapshotsRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer snapshots.Close()
+
+	extraSize, _ := strconv.ParseInt(c.DataDiskExtraSize.Value, 10, 64)
+	var dataDiskSize int64
+	for _, name := range []string{c.SnapshotCH.Value, c.SnapshotOS.Value} {
+		snapshot, err := snapshots.Get(ctx, &computepb.GetSnapshotRequest{
+			Project:  c.GCPProject.Value,
+			Snapshot: name,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to look up snapshot %s: %w", name, err)
+		}
+		dataDiskSize += snapshot.GetDiskSizeGb() + extraSize
+	}
+
+	regions, err := compute.NewRegionsRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer regions.Close()
+
+	region, err := regions.Get(ctx, &computepb.GetRegionRequest{
+		Project: c.GCPProject.Value,
+		Region:  c.GCPRegion.Value,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to look up quota of region %s: %w", c.GCPRegion.Value, err)
+	}
+
+	quotas := map[string]*computepb.Quota{}
+	for _, q := range region.GetQuotas() {
+		quotas[q.GetMetric()] = q
+	}
+
+	// The boot disk is always pd-ssd, the data disks count against standard or
+	// ssd quota depending on their type.
+	needed := map[string]float64{
+		cpuQuotaMetric(c.MachineType.Value, c.ProvisioningModel.Value, quotas): float64(machineType.GetGuestCpus()),
+		"INSTANCES":    1,
+		"SSD_TOTAL_GB": bootDiskSizeGB,
+	}
+	if c.DataDiskType.Value == "pd-standard" {
+		needed["DISKS_TOTAL_GB"] += float64(dataDiskSize)
+	} else {
+		needed["SSD_TOTAL_GB"] += float64(dataDiskSize)
+	}
+	if c.DNSMode.Value != "clouddns" {
+		needed["STATIC_ADDRESSES"] = 1
+	}
+
+	var errs []error
+	metrics := make([]string, 0, len(needed))
+	for metric := range needed {
+		metrics = append(metrics, metric)
+	}
+	slices.Sort(metrics)
+	for _, metric := range metrics {
+		q, ok := quotas[metric]
+		if !ok {
+			continue
+		}
+		if left := q.GetLimit() - q.GetUsage(); left < needed[metric] {
+			errs = append(errs, fmt.Errorf("not enough %s quota in %s: %.0f needed, %.0f left of %.0f", metric, c.GCPRegion.Value, needed[metric], left, q.GetLimit()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// cpuQuotaMetric returns the regional quota metric the CPUs of a machine type
+// count against. N1 and other older families use the generic CPUS quota, the
+// rest have their own. Spot VMs use the preemptible quota if the region has
+// any, and the regular one otherwise.
+func cpuQuotaMetric(machineType, provisioningModel string, quotas map[string]*computepb.Quota) string {
+	if provisioningModel == "SPOT" {
+		if q, ok := quotas["PREEMPTIBLE_CPUS"]; ok && q.GetLimit() > 0 {
+			return "PREEMPTIBLE_CPUS"
+		}
+	}
+
+	family := strings.ToUpper(strings.SplitN(machineType, "-", 2)[0])
+	if _, ok := quotas[family+"_CPUS"]; ok {
+		return family + "_CPUS"
+	}
+	return "CPUS"
+}
+
+// missingPermissions returns an error naming the wanted permissions that were
+// not granted on a resource.
+func missingPermissions(resource string, wanted, granted []string) error {
+	var missing []string
+	for _, p := range wanted {
+		if !slices.Contains(granted, p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions on %s: %s", resource, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// permissionTestError describes a failure to test permissions on a resource.
+func permissionTestError(resource string, err error) error {
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("unable to test permissions on %s: it does not exist", resource)
+	}
+	return fmt.Errorf("unable to test permissions on %s: %w", resource, err)
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Where the value of a setting comes from, from the lowest precedence to the
+// highest.
+const (
+	// SourceDefault is the defaults file, or the built-in default of the setting.
+	SourceDefault = "default"
+	// SourceFile is the config file given with --config.
+	SourceFile = "file"
+	// SourceEnv is an environment variable.
+	SourceEnv = "env"
+	// SourceForm is an edit in the interactive form.
+	SourceForm = "form"
+)
+
+// fileSource returns the source of the values in the config file at configPath,
+// where an empty path stands for the defaults file.
+func fileSource(configPath string) string {
+	if configPath == "" {
+		return SourceDefault
+	}
+	return SourceFile
+}
+
+// SetSources marks the settings of a deployment configuration found in env as
+// coming from source, and the rest as defaults.
+func SetSources(c DeploymentConfig, env map[string]string, source string) {
+	for _, s := range c.GetSettings() {
+		s.Source = SourceDefault
+		if _, ok := env[s.Env]; ok {
+			s.Source = source
+		}
+	}
+}
+
+// RunForm runs the form of a deployment configuration, and marks the settings
+// whose value was changed in it as coming from the form.
+func RunForm(form *huh.Form, c DeploymentConfig) error {
+	before := map[*Setting]string{}
+	for _, s := range c.GetSettings() {
+		before[s] = s.Value
+	}
+
+	if err := form.Run(); err != nil {
+		return err
+	}
+
+	for _, s := range c.GetSettings() {
+		if s.Value != before[s] {
+			s.Source = SourceForm
+		}
+	}
+	return nil
+}
+
+// Summary returns the configuration as ToString does, with the source of every
+// value that is not a default dimmed after it, e.g. `[env]`.
+func Summary(c DeploymentConfig) string {
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("#777777"))
+	settings := map[string]*Setting{}
+	for _, s := range c.GetSettings() {
+		settings[s.Env] = s
+	}
+
+	sb := strings.Builder{}
+	for _, line := range strings.SplitAfter(c.ToString(), "\n") {
+		key, _, ok := strings.Cut(line, "=")
+		s, found := settings[key]
+		if !ok || !found || s.Source == "" || s.Source == SourceDefault {
+			sb.WriteString(line)
+			continue
+		}
+		sb.WriteString(strings.TrimSuffix(line, "\n"))
+		sb.WriteString(dim.Render(" [" + s.Source + "]"))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// SettingReport is a setting of a configuration report, with where its value
+// comes from. The values of secrets are left out.
+type SettingReport struct {
+	Env    string `json:"env"`
+	Title  string `json:"title,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Secret bool   `json:"secret,omitempty"`
+	Source string `json:"source"`
+}
+
+// Report returns the settings of a deployment configuration with their sources.
+func Report(c DeploymentConfig) []SettingReport {
+	var report []SettingReport
+	for _, s := range c.GetSettings() {
+		r := SettingReport{Env: s.Env, Title: s.Title, Secret: s.Secret, Source: s.Source}
+		if r.Source == "" {
+			r.Source = SourceDefault
+		}
+		if !s.Secret {
+			r.Value = s.Value
+		}
+		report = append(report, r)
+	}
+	return report
+}
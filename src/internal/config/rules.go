@@ -0,0 +1,178 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+)
+
+// Rule is a consistency check between a setting and other settings of the same
+// config. Rules are attached to the setting that comes last in the form, and
+// run after its validator both in the form and in Validate. Check gets the value
+// being validated, and reads the other settings through getters.
+type Rule struct {
+	Name  string
+	Check func(v string) error
+}
+
+// checkRules runs the rules attached to a setting and returns the first failure.
+func (s *Setting) checkRules(v string) error {
+	for _, r := range s.Rules {
+		if err := r.Check(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot kinds, used as suffix in snapshot names.
+const (
+	SnapshotKindClickHouse = "ch"
+	SnapshotKindOpenSearch = "os"
+)
+
+// ReleaseLabel returns a release in the form used in snapshot names and labels,
+// which cannot contain dots, e.g. `2509` for `25.09`.
+func ReleaseLabel(release string) string {
+	return strings.ReplaceAll(release, ".", "")
+}
+
+// SnapshotName returns the conventional name of the snapshot of a release, e.g.
+// `platform-2509-ch`.
+func SnapshotName(release, kind string) string {
+	return fmt.Sprintf("platform-%s-%s", ReleaseLabel(release), kind)
+}
+
+// addRules attaches the cross-setting rules of a cloud deployment config.
+func (c *CloudDeploymentConfig) addRules() {
+	getProject := func() string { return c.GCPProject.Value }
+	getRegion := func() string { return c.GCPRegion.Value }
+	getRelease := func() string { return c.Release.Value }
+
+	c.GCPZone.Rules = append(c.GCPZone.Rules,
+		Rule{Name: "zone is in region", Check: RuleZoneInRegion(getRegion)},
+	)
+	c.SnapshotCH.Rules = append(c.SnapshotCH.Rules,
+		Rule{Name: "snapshot matches release", Check: RuleSnapshotMatchesRelease(SnapshotKindClickHouse, getProject, getRelease)},
+		Rule{Name: "snapshot is ready", Check: RuleSnapshotReady(getProject)},
+	)
+	c.SnapshotOS.Rules = append(c.SnapshotOS.Rules,
+		Rule{Name: "snapshot matches release", Check: RuleSnapshotMatchesRelease(SnapshotKindOpenSearch, getProject, getRelease)},
+		Rule{Name: "snapshot is ready", Check: RuleSnapshotReady(getProject)},
+	)
+	c.GCPServiceAccount.Rules = append(c.GCPServiceAccount.Rules,
+		Rule{Name: "service account belongs to project", Check: RuleServiceAccountInProject(getProject)},
+	)
+}
+
+// fillSnapshots sets the snapshots that are not set, or still follow the naming
+// of a previous release, to the conventional names for the current release.
+func (c *CloudDeploymentConfig) fillSnapshots(previousRelease string) {
+	if c.Release.Value == "" {
+		return
+	}
+	for kind, s := range map[string]*Setting{
+		SnapshotKindClickHouse: &c.SnapshotCH,
+		SnapshotKindOpenSearch: &c.SnapshotOS,
+	} {
+		if s.Value == "" || (previousRelease != "" && s.Value == SnapshotName(previousRelease, kind)) {
+			s.Value = SnapshotName(c.Release.Value, kind)
+		}
+	}
+}
+
+// RuleZoneInRegion checks that a zone is inside the region.
+func RuleZoneInRegion(getRegion func() string) func(v string) error {
+	return func(v string) error {
+		region := getRegion()
+		if !strings.HasPrefix(v, region+"-") {
+			return fmt.Errorf("'%s' is not in region '%s'", v, region)
+		}
+		return nil
+	}
+}
+
+// RuleSnapshotMatchesRelease checks that a snapshot holds the data of the
+// release. Snapshots with a release label are checked against it, and the rest
+// by their name, which should contain the release.
+func RuleSnapshotMatchesRelease(kind string, getGCPProject func() string, getRelease func() string) func(v string) error {
+	return func(v string) error {
+		release := getRelease()
+		if release == "" {
+			return nil
+		}
+
+		snapshot, err := getSnapshot(getGCPProject(), v)
+		if err != nil {
+			return err
+		}
+
+		if label, ok := snapshot.GetLabels()["release"]; ok {
+			if label != ReleaseLabel(release) {
+				return fmt.Errorf("'%s' holds release %s, not %s", v, label, release)
+			}
+			return nil
+		}
+
+		if !strings.Contains(v, ReleaseLabel(release)) {
+			return fmt.Errorf("'%s' does not match release %s, try '%s'", v, release, SnapshotName(release, kind))
+		}
+		return nil
+	}
+}
+
+// RuleSnapshotReady checks that a snapshot is ready to create disks from. Its
+// storage location is not checked, as snapshots can be restored in any region.
+func RuleSnapshotReady(getGCPProject func() string) func(v string) error {
+	return func(v string) error {
+		snapshot, err := getSnapshot(getGCPProject(), v)
+		if err != nil {
+			return err
+		}
+
+		if snapshot.GetStatus() != "READY" {
+			return fmt.Errorf("'%s' is not ready, it is %s", v, strings.ToLower(snapshot.GetStatus()))
+		}
+		return nil
+	}
+}
+
+// RuleServiceAccountInProject checks that a user-managed service account belongs
+// to the project. Google-managed accounts are not checked.
+func RuleServiceAccountInProject(getGCPProject func() string) func(v string) error {
+	return func(v string) error {
+		_, domain, ok := strings.Cut(v, "@")
+		if !ok {
+			return errors.New("must be a service account email")
+		}
+		owner, ok := strings.CutSuffix(domain, ".iam.gserviceaccount.com")
+		if !ok {
+			return nil
+		}
+		if project := getGCPProject(); owner != project {
+			return fmt.Errorf("'%s' belongs to project '%s', not '%s'", v, owner, project)
+		}
+		return nil
+	}
+}
+
+// getSnapshot looks up a snapshot in a project.
+func getSnapshot(project, name string) (*computepb.Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	client, err := compute.NewSnapshotsRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	return client.Get(ctx, &computepb.GetSnapshotRequest{
+		Project:  project,
+		Snapshot: name,
+	})
+}
@@ -0,0 +1,74 @@
+package config
+
+import "encoding/json"
+
+// The copy of the schema in ./etc is kept in sync with the settings, which the
+// tests check.
+//go:generate sh -c "go run ../.. config schema > ../../../etc/config.schema.json"
+
+// settingSchema returns the JSON Schema of the value of a setting. Values can
+// always be empty, which leaves them to their default, or unset for settings
+// without one.
+func settingSchema(s *Setting) map[string]any {
+	schema := map[string]any{
+		"type":  "string",
+		"x-env": s.Env,
+	}
+	if s.Title != "" {
+		schema["title"] = s.Title
+	}
+	if s.Description != "" {
+		schema["description"] = s.Description
+	}
+	if s.Pattern != "" {
+		schema["pattern"] = "^$|" + s.Pattern
+	}
+	if len(s.Enum) > 0 {
+		schema["enum"] = append([]string{""}, s.Enum...)
+	}
+	return schema
+}
+
+// CloudConfigSchema returns the JSON Schema of structured cloud deployment
+// configs, generated from the settings: their titles, descriptions and env
+// names, and their patterns and allowed values where they are known.
+func CloudConfigSchema() ([]byte, error) {
+	c := cloudTemplate()
+
+	properties := map[string]any{
+		c.DeploymentType.Key(): map[string]any{
+			"const":       c.DeploymentType.Value,
+			"description": "The type of deployment, always `cloud`.",
+			"x-env":       c.DeploymentType.Env,
+		},
+		extraKey: map[string]any{
+			"type":                 "object",
+			"description":          "Keys that are not settings, by env name, kept as they are in the dotenv config.",
+			"additionalProperties": map[string]any{"type": "string"},
+		},
+	}
+	for _, section := range c.Sections() {
+		settings := map[string]any{}
+		for _, s := range section.Settings {
+			settings[s.Key()] = settingSchema(s)
+		}
+		properties[section.Key] = map[string]any{
+			"type":                 "object",
+			"title":                section.Title,
+			"properties":           settings,
+			"additionalProperties": false,
+		}
+	}
+
+	schema := map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "Open Targets Platform cloud deployment config",
+		"type":                 "object",
+		"properties":           properties,
+		"required":             []string{c.DeploymentType.Key()},
+		"additionalProperties": false,
+	}
+
+	out, err := json.MarshalIndent(schema, "", "  ")
+	return append(out, '\n'), err
+}
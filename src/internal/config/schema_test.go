@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// schemaPath is the copy of the schema kept for editors and CI, relative to
+// this package.
+const schemaPath = "../../../etc/config.schema.json"
+
+func TestCloudConfigSchemaUpToDate(t *testing.T) {
+	want, err := CloudConfigSchema()
+	if err != nil {
+		t.Fatalf("CloudConfigSchema() error = %v", err)
+	}
+	got, err := os.ReadFile(schemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s is out of date with the settings, regenerate it with `go generate ./internal/config`", schemaPath)
+	}
+}
+
+func TestStructuredRoundTrip(t *testing.T) {
+	// Every setting gets a value of its own, along with values that YAML or
+	// JSON could read as something else than the string written.
+	settings := cloudTemplate().GetSettings()
+	env := map[string]string{}
+	for i, s := range settings {
+		env[s.Env] = fmt.Sprintf("value-%d", i)
+	}
+	env["OT_DEPLOYMENT_TYPE"] = "cloud"
+	env["OT_RELEASE"] = "25.10"
+	env["TF_VAR_OT_SUBDOMAIN_NAME"] = "0123"
+	env["TF_VAR_OT_DOMAIN_NAME"] = ""
+	env["TF_VAR_OT_DAYS_TO_LIVE"] = "true"
+	env["TF_VAR_OT_SCHEDULE"] = "mon-fri: 08:00-20:00 # not a comment"
+
+	content := "# config\n"
+	for _, s := range settings {
+		content += s.Env + "=" + tools.QuoteEnvValue(env[s.Env]) + "\n"
+	}
+	content += "OT_CUSTOM_TAG=\"1.0\"\nTF_VAR_BACKEND_BUCKET=\"my-bucket\"\n"
+
+	f, err := tools.ParseEnvFile(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCloudDeploymentConfigFromFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			out, err := c.MarshalStructured(format)
+			if err != nil {
+				t.Fatalf("MarshalStructured() error = %v", err)
+			}
+			path := filepath.Join(t.TempDir(), "config."+format)
+			if err := os.WriteFile(path, out, 0644); err != nil {
+				t.Fatal(err)
+			}
+			rf, err := LoadConfigFile(path)
+			if err != nil {
+				t.Fatalf("LoadConfigFile() error = %v\n%s", err, out)
+			}
+			rc, err := NewCloudDeploymentConfigFromFile(rf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Secrets are left out of structured configs.
+			settings, read := c.GetSettings(), rc.GetSettings()
+			for i, s := range settings {
+				if !s.Secret && read[i].Value != s.Value {
+					t.Errorf("%s = %q after the round trip, want %q", s.Env, read[i].Value, s.Value)
+				}
+			}
+			if extra := DiffExtra(c, rc); len(extra) != 0 {
+				t.Errorf("keys that are not settings changed in the round trip: %v", extra)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+	"gopkg.in/yaml.v3"
+)
+
+// extraKey is the section of structured config files holding keys that are not
+// settings, by their env name, as they are kept in dotenv configs.
+const extraKey = "extra"
+
+// Structured config file formats, by file extension.
+const (
+	FormatDotenv = "dotenv"
+	FormatYAML   = "yaml"
+	FormatJSON   = "json"
+)
+
+// ConfigFormat returns the format of a config file from its extension. Files
+// without a structured extension are dotenv.
+func ConfigFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	}
+	return FormatDotenv
+}
+
+// LoadConfigFile reads a cloud deployment config file from a local path or a
+// GCS URI, in the format told by its extension, as a dotenv file.
+func LoadConfigFile(path string) (*tools.EnvFile, error) {
+	format := ConfigFormat(path)
+	if format == FormatDotenv {
+		return tools.LoadEnvFile(path)
+	}
+
+	var content []byte
+	if strings.HasPrefix(path, "gs://") {
+		c, err := tools.ReadFileFromGCS(path)
+		if err != nil {
+			return nil, err
+		}
+		content = []byte(c)
+	} else {
+		c, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		content = c
+	}
+
+	// Numbers are kept as written, so a release like 25.10 is not read as 25.1.
+	var doc map[string]any
+	if format == FormatJSON {
+		d := json.NewDecoder(bytes.NewReader(content))
+		d.UseNumber()
+		if err := d.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+	} else {
+		var node yaml.Node
+		if err := yaml.Unmarshal(content, &node); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+		m, ok := yamlValue(&node).(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("error parsing %s: expected a mapping of sections", path)
+		}
+		doc = m
+	}
+
+	f, err := structuredToEnv(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error in %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// cloudTemplate returns a config with no values, to look up the settings of
+// structured configs and build the schema.
+func cloudTemplate() *CloudDeploymentConfig {
+	c, _ := NewCloudDeploymentConfigFromEnv(map[string]string{"OT_DEPLOYMENT_TYPE": "cloud"})
+	return c
+}
+
+// structuredToEnv converts a structured config, with the settings nested in the
+// sections of the form, into a dotenv file in the same order as the sections.
+func structuredToEnv(doc map[string]any) (*tools.EnvFile, error) {
+	c := cloudTemplate()
+	f, _ := tools.ParseEnvFile("")
+
+	sections := map[string]SettingSection{}
+	for _, section := range c.Sections() {
+		sections[section.Key] = section
+	}
+	for key := range doc {
+		if _, ok := sections[key]; !ok && key != c.DeploymentType.Key() && key != extraKey {
+			return nil, unknownKeyError(key, append(slices.Collect(maps.Keys(sections)), c.DeploymentType.Key(), extraKey))
+		}
+	}
+
+	if v, ok := doc[c.DeploymentType.Key()]; ok {
+		f.Set(c.DeploymentType.Env, scalarString(v))
+	}
+
+	for _, section := range c.Sections() {
+		raw, ok := doc[section.Key]
+		if !ok || raw == nil {
+			continue
+		}
+		values, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a mapping of settings", section.Key)
+		}
+		settings := map[string]*Setting{}
+		for _, s := range section.Settings {
+			settings[s.Key()] = s
+		}
+		for key := range values {
+			if _, ok := settings[key]; !ok {
+				return nil, unknownKeyError(section.Key+"."+key, prefixed(section.Key, slices.Collect(maps.Keys(settings))))
+			}
+		}
+		for _, s := range section.Settings {
+			if v, ok := values[s.Key()]; ok {
+				f.Set(s.Env, scalarString(v))
+			}
+		}
+	}
+
+	if raw, ok := doc[extraKey]; ok && raw != nil {
+		extra, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a mapping of env names to values", extraKey)
+		}
+		for _, key := range slices.Sorted(maps.Keys(extra)) {
+			f.Set(key, scalarString(extra[key]))
+		}
+	}
+
+	return f, nil
+}
+
+// yamlValue converts a YAML node into mappings of scalars, keeping every
+// scalar as it is written.
+func yamlValue(n *yaml.Node) any {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil
+		}
+		return yamlValue(n.Content[0])
+	case yaml.MappingNode:
+		m := map[string]any{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			m[n.Content[i].Value] = yamlValue(n.Content[i+1])
+		}
+		return m
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return nil
+		}
+	}
+	return n.Value
+}
+
+// scalarString returns a scalar value of a structured config as a string, so
+// numbers and booleans need not be quoted.
+func scalarString(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// unknownKeyError returns an error for an unknown key in a structured config,
+// suggesting the known key it is closest to.
+func unknownKeyError(key string, known []string) error {
+	best, bestDistance := "", maxTypoDistance+1
+	for _, k := range known {
+		if d := editDistance(key, k); d < bestDistance {
+			best, bestDistance = k, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("unknown key %s, did you mean %s?", key, best)
+	}
+	return fmt.Errorf("unknown key %s", key)
+}
+
+// prefixed returns keys prefixed with the key of their section.
+func prefixed(section string, keys []string) []string {
+	for i, k := range keys {
+		keys[i] = section + "." + k
+	}
+	return keys
+}
+
+// structuredValue is a mapping that keeps the order of its keys when encoded
+// as YAML or JSON.
+type structuredValue struct {
+	keys   []string
+	values map[string]any
+}
+
+func newStructuredValue() *structuredValue {
+	return &structuredValue{values: map[string]any{}}
+}
+
+func (m *structuredValue) set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalJSON encodes the mapping as a JSON object in order.
+func (m *structuredValue) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// MarshalYAML encodes the mapping as a YAML mapping in order.
+func (m *structuredValue) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, k := range m.keys {
+		var value yaml.Node
+		if err := value.Encode(m.values[k]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: k}, &value)
+	}
+	return node, nil
+}
+
+// extraKeys returns the keys of the file of a cloud deployment config that are
+// not settings, in file order, or none if it was not read from a file.
+func (c *CloudDeploymentConfig) extraKeys() []string {
+	if c.file == nil {
+		return nil
+	}
+	known := map[string]bool{c.DeploymentType.Env: true}
+	for _, section := range c.Sections() {
+		for _, s := range section.Settings {
+			known[s.Env] = true
+		}
+	}
+	var keys []string
+	for _, key := range c.file.Keys() {
+		if !known[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// extra returns the keys of the file of a cloud deployment config that are not
+// settings, with their values.
+func (c *CloudDeploymentConfig) extra() map[string]string {
+	extra := map[string]string{}
+	if c.file == nil {
+		return extra
+	}
+	env := c.file.Map()
+	for _, key := range c.extraKeys() {
+		extra[key] = env[key]
+	}
+	return extra
+}
+
+// MarshalStructured returns a cloud deployment config as a structured config
+// file in format, with the settings nested in the sections of the form and the
+// keys of its file that are not settings under extra. Secrets are left out.
+func (c *CloudDeploymentConfig) MarshalStructured(format string) ([]byte, error) {
+	doc := newStructuredValue()
+	doc.set(c.DeploymentType.Key(), c.DeploymentType.Value)
+	for _, section := range c.Sections() {
+		values := newStructuredValue()
+		for _, s := range section.Settings {
+			if !s.Secret {
+				values.set(s.Key(), s.Value)
+			}
+		}
+		doc.set(section.Key, values)
+	}
+
+	if keys := c.extraKeys(); len(keys) > 0 {
+		extra := newStructuredValue()
+		env := c.extra()
+		for _, key := range keys {
+			extra.set(key, env[key])
+		}
+		doc.set(extraKey, extra)
+	}
+
+	switch format {
+	case FormatJSON:
+		out, err := json.MarshalIndent(doc, "", "  ")
+		return append(out, '\n'), err
+	case FormatYAML:
+		var buf bytes.Buffer
+		e := yaml.NewEncoder(&buf)
+		e.SetIndent(2)
+		if err := e.Encode(doc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), e.Close()
+	}
+	return nil, fmt.Errorf("unknown structured config format %s", format)
+}
@@ -0,0 +1,55 @@
+package config
+
+import "fmt"
+
+// maxTypoDistance is the largest number of edits for an unknown key to be taken
+// for a typo of a setting.
+const maxTypoDistance = 2
+
+// unknownKeyWarnings returns a warning for every key that is not a setting of a
+// deployment configuration but is a few edits away from one. Other unknown keys
+// are kept without a warning, as they may be used by other tools.
+func unknownKeyWarnings(c DeploymentConfig, keys []string) []string {
+	known := map[string]bool{}
+	for _, s := range c.GetSettings() {
+		known[s.Env] = true
+	}
+
+	var warnings []string
+	for _, key := range keys {
+		if known[key] {
+			continue
+		}
+		best, bestDistance := "", maxTypoDistance+1
+		for _, s := range c.GetSettings() {
+			if d := editDistance(key, s.Env); d < bestDistance {
+				best, bestDistance = s.Env, d
+			}
+		}
+		if best != "" {
+			warnings = append(warnings, fmt.Sprintf("unknown key %s, did you mean %s?", key, best))
+		}
+	}
+	return warnings
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"net/netip"
 	"regexp"
 	"slices"
 	"strconv"
@@ -28,6 +30,25 @@ import (
 
 const gcpContextTimeout = 10 * time.Second
 
+// Patterns of the values of settings, shared by their validators and the config
+// schema.
+const (
+	ReleasePattern       = `^\d{2}.\d{2}$`
+	DomainNamePattern    = `^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`
+	SubdomainNamePattern = `^[a-z0-9]([a-z0-9_-]*[a-z0-9])?$`
+	SchedulePattern      = `^(daily|weekdays) (\d{2}:\d{2})-(\d{2}:\d{2}) (\S+)$`
+	NumberPattern        = `^\d+$`
+)
+
+// Values allowed for settings with a fixed set of them, shared by their
+// validators and the config schema.
+var (
+	WebAppFlavors      = []string{"platform", "ppp"}
+	DNSModes           = []string{"clouddns", "none", "external"}
+	AccessModes        = []string{"none", "basic", "oauth2"}
+	ProvisioningModels = []string{"STANDARD", "SPOT"}
+)
+
 // ValidateNotEmpty checks if the provided string is not empty.
 func ValidateNotEmpty(v string) error {
 	if v == "" {
@@ -47,7 +68,7 @@ func ValidateMaxLength(v string, maxLength int) error {
 
 // ValidateRelease checks if the provided string is a valid release version.
 func ValidateRelease(v string) error {
-	validRelease := regexp.MustCompile(`^\d{2}.\d{2}$`)
+	validRelease := regexp.MustCompile(ReleasePattern)
 	if !validRelease.MatchString(v) {
 		return fmt.Errorf("'%s' has invalid format, it should be '25.06'", v)
 	}
@@ -138,7 +159,7 @@ func ValidateDomainName(v string) error {
 		return err
 	}
 
-	validDomain := regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`)
+	validDomain := regexp.MustCompile(DomainNamePattern)
 	if !validDomain.MatchString(v) {
 		return errors.New("must be a fully qualified domain name with lowercase letters, numbers, and hyphens")
 	}
@@ -156,7 +177,7 @@ func ValidateSubdomainName(v string) error {
 		return err
 	}
 
-	validSubdomain := regexp.MustCompile(`^[a-z0-9]([a-z0-9_-]*[a-z0-9])?$`)
+	validSubdomain := regexp.MustCompile(SubdomainNamePattern)
 	if !validSubdomain.MatchString(v) {
 		return errors.New("only single level subdomains composed of lowercase letters, numbers, hyphens, and underscores are allowed")
 	}
@@ -184,10 +205,161 @@ func ValidateWebAppFlavor(v string) error {
 		return err
 	}
 
-	validFlavors := []string{"platform", "ppp"}
+	if !slices.Contains(WebAppFlavors, v) {
+		return fmt.Errorf("must be one of %s", strings.Join(WebAppFlavors, ", "))
+	}
+
+	return nil
+}
+
+// ValidateProvisioningModel checks if the provided VM provisioning model is valid.
+func ValidateProvisioningModel(v string) error {
+	if err := ValidateNotEmpty(v); err != nil {
+		return err
+	}
+
+	if !slices.Contains(ProvisioningModels, v) {
+		return fmt.Errorf("must be one of %s", strings.Join(ProvisioningModels, ", "))
+	}
+
+	return nil
+}
+
+// ValidateSchedule checks if the provided string is an empty schedule, or days
+// followed by start and stop times and a time zone, e.g. `weekdays 08:00-20:00
+// Europe/London`.
+func ValidateSchedule(v string) error {
+	if v == "" {
+		return nil
+	}
+
+	validSchedule := regexp.MustCompile(SchedulePattern)
+	m := validSchedule.FindStringSubmatch(v)
+	if m == nil {
+		return fmt.Errorf("'%s' has invalid format, it should be 'weekdays 08:00-20:00 Europe/London'", v)
+	}
+
+	start, err := time.Parse("15:04", m[2])
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid time", m[2])
+	}
+	stop, err := time.Parse("15:04", m[3])
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid time", m[3])
+	}
+	if start.Equal(stop) {
+		return errors.New("start and stop times must differ")
+	}
+	if _, err := time.LoadLocation(m[4]); err != nil {
+		return fmt.Errorf("'%s' is not a known time zone", m[4])
+	}
+
+	return nil
+}
+
+// ValidateSourceRanges checks if the provided string is a comma separated list of IPv4 CIDR ranges.
+func ValidateSourceRanges(v string) error {
+	if err := ValidateNotEmpty(v); err != nil {
+		return err
+	}
+
+	for _, r := range strings.Split(v, ",") {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(r))
+		if err != nil || !prefix.Addr().Is4() {
+			return fmt.Errorf("'%s' is not a valid IPv4 CIDR range, e.g. `192.0.2.0/24`", strings.TrimSpace(r))
+		}
+	}
+
+	return nil
+}
+
+// privateRanges are the address ranges that cannot be reached from the internet:
+// private, shared (CGNAT) and loopback space.
+var privateRanges = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+}
+
+// maxPublicBits is the prefix length of the largest public range allowed:
+// source ranges opening as many public addresses as a /16 are as good as open
+// to everyone.
+const maxPublicBits = 16
+
+// isPrivateRange returns true if the whole of prefix is private address space.
+func isPrivateRange(prefix netip.Prefix) bool {
+	for _, p := range privateRanges {
+		if p.Bits() <= prefix.Bits() && p.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicSourceRanges returns true if the source ranges allow access from a
+// large part of the internet, however it is split into ranges, e.g.
+// `0.0.0.0/1,128.0.0.0/1`. Ranges that are not IPv4 CIDR ranges count as
+// public.
+func isPublicSourceRanges(v string) bool {
+	// The public addresses are counted in fractions of the largest allowed
+	// range, where a sum of one or more is too many.
+	var public float64
+	for _, r := range strings.Split(v, ",") {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(r))
+		if err != nil || !prefix.Addr().Is4() {
+			return true
+		}
+		prefix = prefix.Masked()
+		if isPrivateRange(prefix) {
+			continue
+		}
+		public += math.Exp2(float64(maxPublicBits - prefix.Bits()))
+	}
+	return public >= 1
+}
+
+// ValidateAccessMode checks if the provided access mode is valid, and that ppp
+// deployments are never open to everyone.
+func ValidateAccessMode(getWebAppFlavor func() string, getSourceRanges func() string) func(v string) error {
+	return func(v string) error {
+		if err := ValidateNotEmpty(v); err != nil {
+			return err
+		}
 
-	if !slices.Contains(validFlavors, v) {
-		return fmt.Errorf("must be one of %s", strings.Join(validFlavors, ", "))
+		if !slices.Contains(AccessModes, v) {
+			return fmt.Errorf("must be one of %s", strings.Join(AccessModes, ", "))
+		}
+
+		if getWebAppFlavor() == "ppp" && v == "none" && isPublicSourceRanges(getSourceRanges()) {
+			return fmt.Errorf("ppp deployments must restrict the allowed source ranges to less than a /%d of public addresses, or require authentication", maxPublicBits)
+		}
+
+		return nil
+	}
+}
+
+// ValidateGCPAccessSecret checks if the GCP secret holding the access credentials
+// exists when the access mode needs one.
+func ValidateGCPAccessSecret(getAccessMode func() string, getGCPProject func() string) func(v string) error {
+	validateSecret := ValidateGCPSecret(getGCPProject)
+	return func(v string) error {
+		if getAccessMode() == "none" {
+			return nil
+		}
+		return validateSecret(v)
+	}
+}
+
+// ValidateDNSMode checks if the provided DNS mode is valid.
+func ValidateDNSMode(v string) error {
+	if err := ValidateNotEmpty(v); err != nil {
+		return err
+	}
+
+	if !slices.Contains(DNSModes, v) {
+		return fmt.Errorf("must be one of %s", strings.Join(DNSModes, ", "))
 	}
 
 	return nil
@@ -414,7 +586,8 @@ func ValidateGCPSnapshot(GetGCPProject func() string) func(v string) error {
 	}
 }
 
-// ValidateGCPSecret checks if the GCP secret exists, is valid, and accessible.
+// ValidateGCPSecret checks if the GCP secret exists, is valid, and accessible,
+// and has a version for the VM to read.
 func ValidateGCPSecret(GetGCPProject func() string) func(v string) error {
 	return func(v string) error {
 		g := ValidateGCPResource(v)
@@ -453,13 +626,32 @@ func ValidateGCPSecret(GetGCPProject func() string) func(v string) error {
 			}
 			return err
 		}
+
+		// Secrets created by bootstrap have no version until one is added.
+		_, err = client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{
+			Name: fmt.Sprintf("projects/%s/secrets/%s/versions/latest", project, v),
+		})
+		if err != nil {
+			if status.Code(err) == codes.NotFound || status.Code(err) == codes.FailedPrecondition {
+				return fmt.Errorf("'%s' has no enabled version, add one with `gcloud secrets versions add %s`", v, v)
+			}
+			if status.Code(err) == codes.PermissionDenied {
+				return fmt.Errorf("versions of '%s' are forbidden", v)
+			}
+			return err
+		}
 		return nil
 	}
 }
 
-// ValidateGCPCloudDNSZone checks if the GCP Cloud DNS zone exists, is valid, and accessible.
-func ValidateGCPCloudDNSZone(GetGCPProject func() string) func(v string) error {
+// ValidateGCPCloudDNSZone checks if the GCP Cloud DNS zone exists, is valid, and
+// accessible. The zone is only needed when the DNS mode is clouddns.
+func ValidateGCPCloudDNSZone(getDNSMode func() string, GetGCPProject func() string) func(v string) error {
 	return func(v string) error {
+		if getDNSMode() != "clouddns" {
+			return nil
+		}
+
 		g := ValidateGCPResource(v)
 		if g != nil {
 			return g
@@ -585,3 +777,164 @@ func ValidateGCPServiceAccount(GetGCPProject func() string) func(v string) error
 		return nil
 	}
 }
+
+// ValidateDiskExtraSize checks if the extra size for a data disk is a valid number of GB.
+func ValidateDiskExtraSize(v string) error {
+	if err := ValidateNotEmpty(v); err != nil {
+		return err
+	}
+
+	d, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || d < 0 || d > CloudDeploymentMaxDiskExtraSize {
+		return fmt.Errorf("must be a number of GB between 0 and %d", CloudDeploymentMaxDiskExtraSize)
+	}
+
+	return nil
+}
+
+// ValidateGCPMachineType checks if the GCP machine type exists and is available in the zone.
+func ValidateGCPMachineType(getGCPProject func() string, getGCPZone func() string) func(v string) error {
+	return func(v string) error {
+		g := ValidateGCPResource(v)
+		if g != nil {
+			return g
+		}
+
+		project := getGCPProject()
+		if project == "" {
+			return errors.New("gcp project is not set")
+		}
+		zone := getGCPZone()
+		if zone == "" {
+			return errors.New("gcp zone is not set")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+		defer cancel()
+
+		client, err := compute.NewMachineTypesRESTClient(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to access google cloud: %w", err)
+		}
+		defer client.Close()
+
+		req := &computepb.GetMachineTypeRequest{
+			Project:     project,
+			Zone:        zone,
+			MachineType: v,
+		}
+
+		_, err = client.Get(ctx, req)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return fmt.Errorf("'%s' is not available in %s", v, zone)
+			}
+			if status.Code(err) == codes.InvalidArgument {
+				return fmt.Errorf("'%s' is unknown", v)
+			}
+			if status.Code(err) == codes.PermissionDenied {
+				return fmt.Errorf("'%s' is forbidden", v)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// ValidateGCPDiskType checks if the GCP disk type exists and is available in the zone.
+func ValidateGCPDiskType(getGCPProject func() string, getGCPZone func() string) func(v string) error {
+	return func(v string) error {
+		g := ValidateGCPResource(v)
+		if g != nil {
+			return g
+		}
+
+		project := getGCPProject()
+		if project == "" {
+			return errors.New("gcp project is not set")
+		}
+		zone := getGCPZone()
+		if zone == "" {
+			return errors.New("gcp zone is not set")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+		defer cancel()
+
+		client, err := compute.NewDiskTypesRESTClient(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to access google cloud: %w", err)
+		}
+		defer client.Close()
+
+		req := &computepb.GetDiskTypeRequest{
+			Project:  project,
+			Zone:     zone,
+			DiskType: v,
+		}
+
+		_, err = client.Get(ctx, req)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return fmt.Errorf("'%s' is not available in %s", v, zone)
+			}
+			if status.Code(err) == codes.InvalidArgument {
+				return fmt.Errorf("'%s' is unknown", v)
+			}
+			if status.Code(err) == codes.PermissionDenied {
+				return fmt.Errorf("'%s' is forbidden", v)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// ValidateGCPImage checks if the GCP boot image exists and is accessible. The
+// image is given as `project/family` or `project/image`, like in terraform.
+func ValidateGCPImage(v string) error {
+	parts := strings.Split(v, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("'%s' must be in the form project/family or project/image, e.g. `debian-cloud/debian-12`", v)
+	}
+	for _, p := range parts {
+		if g := ValidateGCPResource(p); g != nil {
+			return g
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	client, err := compute.NewImagesRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	// Terraform accepts both families and image names, so try the family first.
+	_, err = client.GetFromFamily(ctx, &computepb.GetFromFamilyImageRequest{
+		Project: parts[0],
+		Family:  parts[1],
+	})
+	if err == nil {
+		return nil
+	}
+	_, err = client.Get(ctx, &computepb.GetImageRequest{
+		Project: parts[0],
+		Image:   parts[1],
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("'%s' does not exist", v)
+		}
+		if status.Code(err) == codes.InvalidArgument {
+			return fmt.Errorf("'%s' is unknown", v)
+		}
+		if status.Code(err) == codes.PermissionDenied {
+			return fmt.Errorf("'%s' is forbidden", v)
+		}
+		return err
+	}
+	return nil
+}
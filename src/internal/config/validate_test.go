@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestIsPublicSourceRanges(t *testing.T) {
+	tests := []struct {
+		ranges string
+		want   bool
+	}{
+		{"0.0.0.0/0", true},
+		{"0.0.0.0/1,128.0.0.0/1", true},
+		{"203.0.113.0/24, 0.0.0.0/0", true},
+		{"198.51.0.0/16", true},
+		{"198.51.0.0/17,198.51.128.0/17", true},
+		{"198.51.0.0/17", false},
+		{"192.0.2.0/24,198.51.100.7/32", false},
+		{"10.0.0.0/8", false},
+		{"10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,100.64.0.0/10", false},
+		{"8.0.0.0/7", true},
+		{"not a range", true},
+	}
+	for _, tt := range tests {
+		if got := isPublicSourceRanges(tt.ranges); got != tt.want {
+			t.Errorf("isPublicSourceRanges(%q) = %v, want %v", tt.ranges, got, tt.want)
+		}
+	}
+}
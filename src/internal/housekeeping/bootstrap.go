@@ -0,0 +1,191 @@
+package housekeeping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// bootstrapStateName is the name of the bootstrap terraform state in the ops
+// URI. It has an extension, so it is not taken for a deployment config.
+const bootstrapStateName = "bootstrap.tfstate"
+
+// BootstrapConfig holds the settings of the cloud prerequisites for a project.
+type BootstrapConfig struct {
+	Project          string
+	Region           string
+	Zone             string
+	OpsURI           string
+	ServiceAccount   string
+	Network          string
+	SubnetworkRange  string
+	SecretAIToken    string
+	SecretAccess     string
+	DomainName       string
+	DefaultsPath     string
+	DefaultsTemplate string
+}
+
+// GetDeploymentDir returns the directory where the bootstrap files are stored.
+func (b *BootstrapConfig) GetDeploymentDir() string {
+	return fmt.Sprintf("bootstrap-%s", b.Project)
+}
+
+// opsBucket returns the bucket and prefix of the ops URI.
+func (b *BootstrapConfig) opsBucket() (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(b.OpsURI, "gs://"), "/", 2)
+	if !strings.HasPrefix(b.OpsURI, "gs://") || len(parts) < 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid ops uri: %s", b.OpsURI)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Bootstrap creates the service account, network, secrets and ops bucket that
+// cloud deployments need in a project, and writes a defaults file for it.
+// Terraform output is also written to w if it is not nil. It returns the
+// terraform outputs.
+func Bootstrap(b *BootstrapConfig, w io.Writer) (map[string]string, error) {
+	bucket, prefix, err := b.opsBucket()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := b.GetDeploymentDir()
+	if err := EnsureDir(dir); err != nil {
+		return nil, err
+	}
+	if err := copyFile("./etc/bootstrap/main.tf", filepath.Join(dir, "main.tf")); err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{
+		"OT_GCP_PROJECT":          b.Project,
+		"OT_GCP_REGION":           b.Region,
+		"OT_GCP_ZONE":             b.Zone,
+		"OT_OPS_BUCKET":           bucket,
+		"OT_GCP_SA_NAME":          b.ServiceAccount,
+		"OT_GCP_NETWORK":          b.Network,
+		"OT_GCP_SUBNETWORK_RANGE": b.SubnetworkRange,
+		"OT_GCP_SECRET_AI_TOKEN":  b.SecretAIToken,
+		"OT_GCP_SECRET_ACCESS":    b.SecretAccess,
+		"OT_DOMAIN_NAME":          b.DomainName,
+	}
+	if err := writeTerraformVars(dir, vars); err != nil {
+		return nil, err
+	}
+
+	// The bucket does not exist on the first run, so the state is kept locally
+	// and copied to the bucket afterwards. Running again from another machine
+	// picks it up from there.
+	stateURI := fmt.Sprintf("%s/%s", b.OpsURI, bootstrapStateName)
+	statePath := filepath.Join(dir, "terraform.tfstate")
+	if _, err := os.Stat(statePath); os.IsNotExist(err) {
+		state, err := tools.ReadFileFromGCS(stateURI)
+		switch {
+		case err == nil:
+			if err := os.WriteFile(statePath, []byte(state), 0600); err != nil {
+				return nil, fmt.Errorf("error writing bootstrap state %s: %w", statePath, err)
+			}
+		case !errors.Is(err, storage.ErrObjectNotExist) && !errors.Is(err, storage.ErrBucketNotExist):
+			return nil, fmt.Errorf("error reading bootstrap state %s: %w", stateURI, err)
+		}
+	}
+
+	tf, closeLog, err := installTerraform(dir, w)
+	if err != nil {
+		return nil, err
+	}
+	defer closeLog()
+
+	if err := tf.Init(context.Background()); err != nil {
+		return nil, fmt.Errorf("error initializing terraform: %w", err)
+	}
+	if err := tf.Apply(context.Background()); err != nil {
+		return nil, fmt.Errorf("error applying terraform configuration: %w", err)
+	}
+
+	state, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bootstrap state %s: %w", statePath, err)
+	}
+	if err := tools.WriteFileToGCS(stateURI, string(state)); err != nil {
+		return nil, fmt.Errorf("error uploading bootstrap state to %s: %w", stateURI, err)
+	}
+
+	outputs, err := terraformOutputs(tf)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeBootstrapDefaults(b, bucket, prefix, outputs); err != nil {
+		return nil, err
+	}
+
+	return outputs, nil
+}
+
+// writeBootstrapDefaults writes a defaults file for the bootstrapped project,
+// based on the defaults template with the project settings replaced.
+func writeBootstrapDefaults(b *BootstrapConfig, bucket, prefix string, outputs map[string]string) error {
+	defaults, err := tools.LoadEnvFile(b.DefaultsTemplate)
+	if err != nil {
+		return fmt.Errorf("error reading defaults template %s: %w", b.DefaultsTemplate, err)
+	}
+
+	dnsMode := "none"
+	if b.DomainName != "" {
+		dnsMode = "clouddns"
+	}
+
+	// The values are set in the template where they are assigned, keeping its
+	// comments and ordering, and quoted so the file can be sourced.
+	for _, kv := range [][2]string{
+		{"TF_VAR_OT_GCP_PROJECT", b.Project},
+		{"TF_VAR_OT_GCP_REGION", b.Region},
+		{"TF_VAR_OT_GCP_ZONE", b.Zone},
+		{"OT_OPS_URI", b.OpsURI},
+		{"TF_VAR_OT_DOMAIN_NAME", b.DomainName},
+		{"TF_VAR_OT_DNS_MODE", dnsMode},
+		{"TF_VAR_OT_GCP_SECRET_AI_TOKEN", b.SecretAIToken},
+		{"TF_VAR_OT_GCP_SECRET_ACCESS", b.SecretAccess},
+		{"TF_VAR_OT_GCP_CLOUD_DNS_ZONE", outputs["cloud_dns_zone"]},
+		{"TF_VAR_OT_GCP_NETWORK", b.Network},
+		{"TF_VAR_OT_GCP_SA", outputs["service_account"]},
+		{"TF_VAR_BACKEND_BUCKET", bucket},
+		{"TF_VAR_BACKEND_PREFIX", prefix},
+	} {
+		defaults.Set(kv[0], kv[1])
+	}
+
+	if err := os.WriteFile(b.DefaultsPath, []byte(defaults.String()), 0644); err != nil {
+		return fmt.Errorf("error writing defaults file %s: %w", b.DefaultsPath, err)
+	}
+	return nil
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening source file %s: %w", src, err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("error creating destination file %s: %w", dst, err)
+	}
+	defer dstFile.Close()
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return fmt.Errorf("error copying file from %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
@@ -3,18 +3,13 @@ package housekeeping
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"sync"
-	"time"
 
-	"github.com/hashicorp/hc-install/product"
-	"github.com/hashicorp/hc-install/releases"
-	"github.com/hashicorp/terraform-exec/tfexec"
-	"github.com/joho/godotenv"
 	"github.com/opentargets/platform-deployment-standalone/internal/config"
 	"github.com/opentargets/platform-deployment-standalone/internal/tools"
 )
@@ -26,8 +21,6 @@ var dataImagePaths = map[string]string{
 
 // DeployLocal executes a local deployment command using Terraform.
 func DeployLocal(c *config.LocalDeploymentConfig) {
-	godotenv.Load(c.GetDeploymentDir() + "/config")
-
 	downloadsDir, err := filepath.Abs("./downloads")
 	if err != nil {
 		log.Fatalf("error getting absolute path of downloads dir: %v\n", err)
@@ -86,6 +79,7 @@ func DeployLocal(c *config.LocalDeploymentConfig) {
 
 	startAction := func() {
 		cmd := exec.Command("docker", "compose", "--file", fmt.Sprintf("%s/compose.yaml", c.GetDeploymentDir()), "up", "-d", "--quiet-build", "--quiet-pull", "--build", "--force-recreate")
+		cmd.Env = commandEnv(config.Env(c))
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		err := cmd.Run()
@@ -98,70 +92,28 @@ func DeployLocal(c *config.LocalDeploymentConfig) {
 	fmt.Println("deployment successful, check out http://localhost:8080")
 }
 
-// DeployCloud executes a cloud deployment command using Terraform.
-func DeployCloud(c *config.CloudDeploymentConfig) {
-	godotenv.Load(c.GetDeploymentDir() + "/config")
-
-	logFilename := fmt.Sprintf("terraform-%s.log", time.Now().Format("2006-01-02-150405"))
-	logFilepath := filepath.Join(c.GetDeploymentDir(), logFilename)
-	logFile, err := os.Create(logFilepath)
+// DeployCloud executes a cloud deployment command using Terraform. Terraform
+// output is also written to w if it is not nil. It returns the terraform
+// outputs, such as the instance URL and, when the DNS is managed externally, the
+// record to create.
+func DeployCloud(c *config.CloudDeploymentConfig, w io.Writer) (map[string]string, error) {
+	tf, closeLog, err := newTerraform(c, w)
 	if err != nil {
-		log.Fatalf("error opening log file %s: %v\n", logFilepath, err)
-	}
-	defer logFile.Close()
-
-	installer := &releases.LatestVersion{
-		Product: product.Terraform,
-	}
-
-	execPath, err := installer.Install(context.Background())
-	if err != nil {
-		log.Fatalf("error installing terraform: %v\n", err)
-	}
-
-	workingDir := c.GetDeploymentDir()
-	tf, err := tfexec.NewTerraform(workingDir, execPath)
-	if err != nil {
-		log.Fatalf("error creating terraform instance: %v\n", err)
-	}
-
-	tf.SetStderr(logFile)
-	tf.SetStdout(logFile)
-
-	parts := strings.SplitN(strings.TrimPrefix(c.OpsURI.Value, "gs://"), "/", 2)
-	if len(parts) < 2 {
-		log.Fatalf("invalid ops uri: %s", c.OpsURI.Value)
-	}
-	bucket := fmt.Sprintf("bucket=%s", parts[0])
-	prefix := fmt.Sprintf("prefix=%s", parts[1])
-
-	err = tf.Init(
-		context.Background(),
-		tfexec.Upgrade(true),
-		tfexec.BackendConfig(bucket),
-		tfexec.BackendConfig(prefix),
-	)
-	if err != nil {
-		log.Fatalf("error initializing terraform: %v\n", err)
-	}
-
-	err = tf.WorkspaceSelect(context.Background(), c.SubdomainName.Value)
-	if err != nil {
-		err = tf.WorkspaceNew(context.Background(), c.SubdomainName.Value)
-		if err != nil {
-			log.Fatalf("error selecting or creating workspace: %v\n", err)
-		}
+		return nil, err
 	}
+	defer closeLog()
 
 	err = tf.Apply(context.Background())
 	if err != nil {
-		log.Fatalf("error applying terraform configuration: %v\n", err)
+		return nil, fmt.Errorf("error applying terraform configuration: %w", err)
 	}
 
 	// We need to do this twice because if the change includes a new data volume,
 	// the first apply will create the volume but not attach it to the instance.
 	err = tf.Apply(context.Background())
 	if err != nil {
-		log.Fatalf("error applying terraform configuration: %v\n", err)
+		return nil, fmt.Errorf("error applying terraform configuration: %w", err)
 	}
+
+	return terraformOutputs(tf)
 }
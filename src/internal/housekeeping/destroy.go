@@ -3,17 +3,11 @@ package housekeeping
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/hashicorp/hc-install/product"
-	"github.com/hashicorp/hc-install/releases"
-	"github.com/hashicorp/terraform-exec/tfexec"
-	"github.com/joho/godotenv"
 	"github.com/opentargets/platform-deployment-standalone/internal/config"
 	"github.com/opentargets/platform-deployment-standalone/internal/tools"
 )
@@ -24,16 +18,16 @@ func Destroy(deploymentPath string) {
 		if strings.HasPrefix(deploymentPath, "gs://") {
 			destroyCloudDeployment(deploymentPath)
 		} else {
-			env, err := godotenv.Read(deploymentPath + "/config")
+			env, err := tools.LoadEnvFromFile(deploymentPath + "/config")
 			if err != nil {
 				log.Fatalf("error reading config file in specified folder: %v", err)
 			}
 
 			switch env["OT_DEPLOYMENT_TYPE"] {
 			case "local":
-				destroyLocalDeployment(deploymentPath)
+				destroyLocalDeployment(deploymentPath, env)
 			case "cloud":
-				destroyCloudDeployment(deploymentPath)
+				destroyCloudDeployment(deploymentPath + "/config")
 			default:
 				log.Fatalf("unknown deployment type: %s", env["DEPLOYMENT_TYPE"])
 			}
@@ -43,77 +37,72 @@ func Destroy(deploymentPath string) {
 	tools.RunWithSpinner("destroying deployment", action)
 }
 
-func destroyLocalDeployment(deploymentPath string) {
-	err := exec.Command("docker-compose", "-f", deploymentPath+"/compose.yaml", "down").Run()
+func destroyLocalDeployment(deploymentPath string, env map[string]string) {
+	cmd := exec.Command("docker-compose", "-f", deploymentPath+"/compose.yaml", "down")
+	cmd.Env = commandEnv(env)
+	err := cmd.Run()
 	if err != nil {
 		log.Fatalf("error destroying local deployment: %v", err)
 	}
 	log.Printf("local deployment %s destroyed", deploymentPath)
 }
 
-func destroyCloudDeployment(deploymentPath string) {
+// DestroyCloud destroys the cloud deployment whose config is found at
+// deploymentPath, either a local file or a GCS URI. Terraform
+// output is also written to w if it is not nil.
+//
+// It also reconciles deployments whose VM deleted itself on expiry: terraform
+// drops what is already gone from the state and destroys what is left, such as
+// the static address. Either way, the workspace is then removed and the config
+// archived, so the deployment is no longer listed.
+func DestroyCloud(deploymentPath string, w io.Writer) error {
 	c, err := config.NewCloudDeploymentConfig(deploymentPath)
 	if err != nil {
-		log.Fatalf("error loading cloud deployment config: %v", err)
+		return fmt.Errorf("error loading cloud deployment config: %w", err)
 	}
-	PrepareDeploymentDir(c)
-
-	godotenv.Load(c.GetDeploymentDir() + "/config")
-
-	logFilename := fmt.Sprintf("terraform-%s.log", time.Now().Format("2006-01-02-150405"))
-	logFilepath := filepath.Join(c.GetDeploymentDir(), logFilename)
-	logFile, err := os.Create(logFilepath)
+	unlock, err := Lock(c, "destroy")
 	if err != nil {
-		log.Fatalf("error opening log file %s: %v\n", logFilepath, err)
+		return err
 	}
-	defer logFile.Close()
+	defer unlock()
 
-	installer := &releases.LatestVersion{
-		Product: product.Terraform,
+	if err := PrepareDeploymentDir(c); err != nil {
+		return err
+	}
+	if err := WriteConfig(c); err != nil {
+		return err
 	}
 
-	execPath, err := installer.Install(context.Background())
+	tf, closeLog, err := newTerraform(c, w)
 	if err != nil {
-		log.Fatalf("error installing terraform: %v\n", err)
+		return err
 	}
+	defer closeLog()
 
-	workingDir := c.GetDeploymentDir()
-	tf, err := tfexec.NewTerraform(workingDir, execPath)
+	err = tf.Destroy(context.Background())
 	if err != nil {
-		log.Fatalf("error creating terraform instance: %v\n", err)
+		return fmt.Errorf("error destroying terraform deployment: %w", err)
 	}
 
-	tf.SetStderr(logFile)
-	tf.SetStdout(logFile)
-
-	parts := strings.SplitN(strings.TrimPrefix(c.OpsURI.Value, "gs://"), "/", 2)
-	if len(parts) < 2 {
-		log.Fatalf("invalid ops uri: %s", c.OpsURI.Value)
+	// The current workspace cannot be deleted, and the default one always exists.
+	if err := tf.WorkspaceSelect(context.Background(), "default"); err != nil {
+		return fmt.Errorf("error selecting default workspace: %w", err)
 	}
-	bucket := fmt.Sprintf("bucket=%s", parts[0])
-	prefix := fmt.Sprintf("prefix=%s", parts[1])
-
-	err = tf.Init(
-		context.Background(),
-		tfexec.Upgrade(true),
-		tfexec.BackendConfig(bucket),
-		tfexec.BackendConfig(prefix),
-	)
-	if err != nil {
-		log.Fatalf("error initializing terraform: %v\n", err)
+	if err := tf.WorkspaceDelete(context.Background(), c.SubdomainName.Value); err != nil {
+		return fmt.Errorf("error deleting workspace %s: %w", c.SubdomainName.Value, err)
 	}
 
-	err = tf.WorkspaceSelect(context.Background(), c.SubdomainName.Value)
+	return ArchiveConfig(c)
+}
+
+func destroyCloudDeployment(deploymentPath string) {
+	c, err := config.NewCloudDeploymentConfig(deploymentPath)
 	if err != nil {
-		err = tf.WorkspaceNew(context.Background(), c.SubdomainName.Value)
-		if err != nil {
-			log.Fatalf("error selecting or creating workspace: %v\n", err)
-		}
+		log.Fatalf("error loading cloud deployment config: %v", err)
 	}
 
-	err = tf.Destroy(context.Background())
-	if err != nil {
-		log.Fatalf("error destroying terraform deployment: %v\n", err)
+	if err := DestroyCloud(deploymentPath, nil); err != nil {
+		log.Fatal(err)
 	}
 
 	log.Printf("cloud deployment %s destroyed, you can now safely delete the folder %s", c.SubdomainName.Value, c.GetDeploymentDir())
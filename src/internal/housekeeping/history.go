@@ -0,0 +1,97 @@
+package housekeeping
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// historyFolder is the folder of the ops URI where every config deployed is
+// kept, in a folder per deployment.
+const historyFolder = "history"
+
+// ConfigVersion is a config deployed at some point, kept in the history folder
+// of the ops URI.
+type ConfigVersion struct {
+	Version    int       `json:"version"`
+	Config     string    `json:"config"`
+	Author     string    `json:"author"`
+	CreatedAt  time.Time `json:"created_at"`
+	ConfigHash string    `json:"config_hash"`
+}
+
+// ConfigChange is a value that differs between two configs. Old or New are
+// empty if the key is missing on that side.
+type ConfigChange struct {
+	Key string
+	Old string
+	New string
+}
+
+// recordVersion keeps a copy of the config of a deployment in its history
+// folder, and adds it to the history in its metadata, unless it is the same as
+// the latest version.
+func recordVersion(m *Metadata, c *config.CloudDeploymentConfig, author string, now time.Time) error {
+	hash := ConfigHash(c)
+	if n := len(m.History); n > 0 && m.History[n-1].ConfigHash == hash {
+		return nil
+	}
+
+	versionURI := fmt.Sprintf("%s/%s/%s/%s", c.OpsURI.Value, historyFolder, c.SubdomainName.Value, now.Format("20060102T150405Z"))
+	if err := tools.WriteFileToGCS(versionURI, c.ToString()); err != nil {
+		return fmt.Errorf("error writing config version to %s: %w", versionURI, err)
+	}
+
+	m.History = append(m.History, ConfigVersion{
+		Version:    len(m.History) + 1,
+		Config:     versionURI,
+		Author:     author,
+		CreatedAt:  now,
+		ConfigHash: hash,
+	})
+	return nil
+}
+
+// FindVersion returns a version from the history in the metadata of a
+// deployment.
+func FindVersion(m *Metadata, version int) (ConfigVersion, error) {
+	for _, v := range m.History {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return ConfigVersion{}, fmt.Errorf("version %d of %s not found, it has versions 1 to %d", version, m.Name, len(m.History))
+}
+
+// DiffConfigs returns the values that differ between two configs, sorted by
+// key.
+func DiffConfigs(oldConfig, newConfig string) ([]ConfigChange, error) {
+	oldEnv, err := tools.ParseEnv(oldConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config: %w", err)
+	}
+	newEnv, err := tools.ParseEnv(newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config: %w", err)
+	}
+
+	keys := slices.Collect(maps.Keys(oldEnv))
+	for k := range newEnv {
+		if _, ok := oldEnv[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	var changes []ConfigChange
+	for _, k := range keys {
+		if oldEnv[k] != newEnv[k] {
+			changes = append(changes, ConfigChange{Key: k, Old: oldEnv[k], New: newEnv[k]})
+		}
+	}
+	return changes, nil
+}
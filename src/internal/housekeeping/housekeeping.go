@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"os"
@@ -16,22 +15,29 @@ import (
 	"strings"
 	"time"
 
-	"github.com/joho/godotenv"
+	"cloud.google.com/go/storage"
 	"github.com/opentargets/platform-deployment-standalone/internal/config"
 	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// archiveFolder is the folder of the ops URI destroyed deployment configs are
+// moved to.
+const archiveFolder = "archive"
+
 // EnsureDir checks if the deployment directory exists and creates it if not.
-func EnsureDir(path string) {
+func EnsureDir(path string) error {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		if err := os.MkdirAll(path, 0755); err != nil {
-			log.Fatalf("error creating deployment directory %s: %v", path, err)
+			return fmt.Errorf("error creating deployment directory %s: %w", path, err)
 		}
 	}
+	return nil
 }
 
 // PrepareDeploymentDir creates the deployment directory and copies necessary files.
-func PrepareDeploymentDir(c config.DeploymentConfig) {
+func PrepareDeploymentDir(c config.DeploymentConfig) error {
 	localDeploymentFiles := []string{
 		"./etc/compose.yaml",
 		"./etc/Dockerfile-opensearch",
@@ -48,7 +54,9 @@ func PrepareDeploymentDir(c config.DeploymentConfig) {
 		"./etc/nginx.conf.tftpl",
 	}
 
-	EnsureDir(c.GetDeploymentDir())
+	if err := EnsureDir(c.GetDeploymentDir()); err != nil {
+		return err
+	}
 
 	var filesToCopy []string
 	switch c.(type) {
@@ -61,59 +69,135 @@ func PrepareDeploymentDir(c config.DeploymentConfig) {
 	for _, filename := range filesToCopy {
 		srcPath, err := filepath.Abs(filename)
 		if err != nil {
-			log.Fatalf("error getting absolute path for %s: %v", filename, err)
+			return fmt.Errorf("error getting absolute path for %s: %w", filename, err)
 		}
 
 		dstPath := filepath.Join(c.GetDeploymentDir(), filepath.Base(filename))
 
 		srcFile, err := os.Open(srcPath)
 		if err != nil {
-			log.Fatalf("error opening source file %s: %v", srcPath, err)
+			return fmt.Errorf("error opening source file %s: %w", srcPath, err)
 		}
 		defer srcFile.Close()
 
 		dstFile, err := os.Create(dstPath)
 		if err != nil {
-			log.Fatalf("error creating destination file %s: %v", dstPath, err)
+			return fmt.Errorf("error creating destination file %s: %w", dstPath, err)
 		}
 		defer dstFile.Close()
 
 		if _, err := io.Copy(dstFile, srcFile); err != nil {
-			log.Fatalf("error copying file from %s to %s: %v", srcPath, dstPath, err)
+			return fmt.Errorf("error copying file from %s to %s: %w", srcPath, dstPath, err)
 		}
 	}
+
+	return nil
 }
 
 // WriteConfig writes the deployment configuration to its deployment directory.
-func WriteConfig(c config.DeploymentConfig) {
+func WriteConfig(c config.DeploymentConfig) error {
 	deploymentDir := c.GetDeploymentDir()
-	EnsureDir(deploymentDir)
+	if err := EnsureDir(deploymentDir); err != nil {
+		return err
+	}
 
 	configFilePath := deploymentDir + "/config"
 	if err := os.WriteFile(configFilePath, []byte(c.ToString()), 0644); err != nil {
-		log.Fatalf("error writing config file %s: %v", configFilePath, err)
+		return fmt.Errorf("error writing config file %s: %w", configFilePath, err)
 	}
 
 	for _, s := range c.GetSecretFields() {
 		if s.Secret {
 			secretFilePath := deploymentDir + "/" + s.SecretFilename
 			if err := os.WriteFile(secretFilePath, []byte(s.Value), 0600); err != nil {
-				log.Fatalf("error writing secret file %s: %v", secretFilePath, err)
+				return fmt.Errorf("error writing secret file %s: %w", secretFilePath, err)
 			}
 		}
 	}
+
+	return nil
 }
 
-// UploadConfig writes a cloud deployment configuration to a GCS uri.
-func UploadConfig(c *config.CloudDeploymentConfig) error {
+// UploadConfig writes a cloud deployment configuration to a GCS uri, along with
+// its metadata document, given the terraform outputs of the deploy, and
+// registers the deployment. The config is only written if it is still at
+// generation, as found before deploying, 0 meaning there was none, so configs
+// changed by others in the meantime are not overwritten.
+func UploadConfig(c *config.CloudDeploymentConfig, outputs map[string]string, generation int64) error {
 	configFileURI := fmt.Sprintf("%s/%s", c.OpsURI.Value, c.SubdomainName.Value)
 
-	if err := tools.WriteFileToGCS(configFileURI, c.ToString()); err != nil {
+	err := tools.WriteFileToGCSIfGeneration(configFileURI, c.ToString(), generation)
+	if tools.IsPreconditionFailed(err) {
+		return fmt.Errorf("config %s was changed by someone else while deploying, check it with `platform status %s`", configFileURI, c.SubdomainName.Value)
+	}
+	if err != nil {
+		return err
+	}
+	m, err := writeMetadata(c, outputs)
+	if err != nil {
+		return fmt.Errorf("error writing metadata: %w", err)
+	}
+	return register(c, m)
+}
+
+// ArchiveConfig removes a destroyed cloud deployment from the registry, and
+// moves its config and metadata document from the ops URI into its archive
+// folder, so it is no longer listed but can still be looked at. Deployments
+// whose config was never uploaded are skipped.
+func ArchiveConfig(c *config.CloudDeploymentConfig) error {
+	if err := unregister(c); err != nil {
 		return err
 	}
+
+	configFileURI := fmt.Sprintf("%s/%s", c.OpsURI.Value, c.SubdomainName.Value)
+	content, err := tools.ReadFileFromGCS(configFileURI)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading config %s: %w", configFileURI, err)
+	}
+
+	archiveURI := fmt.Sprintf("%s/%s/%s-%s", c.OpsURI.Value, archiveFolder, c.SubdomainName.Value, time.Now().UTC().Format("20060102T150405Z"))
+	if err := tools.WriteFileToGCS(archiveURI, content); err != nil {
+		return fmt.Errorf("error archiving config to %s: %w", archiveURI, err)
+	}
+	if err := tools.DeleteFileFromGCS(configFileURI); err != nil {
+		return fmt.Errorf("error deleting config %s: %w", configFileURI, err)
+	}
+
+	metadataFileURI := metadataURI(c.OpsURI.Value, c.SubdomainName.Value)
+	metadata, err := tools.ReadFileFromGCS(metadataFileURI)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading metadata %s: %w", metadataFileURI, err)
+	}
+	if err := tools.WriteFileToGCS(archiveURI+metadataSuffix, metadata); err != nil {
+		return fmt.Errorf("error archiving metadata to %s: %w", archiveURI+metadataSuffix, err)
+	}
+	if err := tools.DeleteFileFromGCS(metadataFileURI); err != nil {
+		return fmt.Errorf("error deleting metadata %s: %w", metadataFileURI, err)
+	}
 	return nil
 }
 
+// ListDeployments returns the URIs of the cloud deployment configs registered
+// in an ops URI.
+func ListDeployments(backend string) ([]string, error) {
+	r, err := ReadRegistry(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []string
+	for _, e := range r.Entries() {
+		configs = append(configs, e.Config)
+	}
+	return configs, nil
+}
+
 // CheckInstance checks the state of an Open Targets instance.
 func CheckInstance(configFilename string) (string, string) {
 	config, err := tools.ReadFileFromGCS(configFilename)
@@ -121,14 +205,43 @@ func CheckInstance(configFilename string) (string, string) {
 		return "unknown url", fmt.Sprintf("error: unable to read config file %s: %v", configFilename, err)
 
 	}
-	env, err := godotenv.Unmarshal(config)
+	env, err := tools.ParseEnv(config)
 	if err != nil {
 		return "unknown url", fmt.Sprintf("error: unable to parse config file %s: %v\n", configFilename, err)
 	}
+	return checkInstance(env)
+}
+
+// checkInstance checks the state of the Open Targets instance of a config.
+func checkInstance(env map[string]string) (string, string) {
+	// A stopped or preempted VM is not an error, it can be started again, and a
+	// VM that is gone has expired. It is only gone if its project and zone are
+	// there, as reconcile destroys gone deployments. If the VM cannot be looked
+	// up otherwise, the API check below reports what is wrong.
+	state, stateErr := getInstanceState(env["TF_VAR_OT_GCP_PROJECT"], env["TF_VAR_OT_GCP_ZONE"], instanceName(env["TF_VAR_OT_SUBDOMAIN_NAME"]))
+	if status.Code(stateErr) == codes.NotFound {
+		if err := checkInstanceGone(env["TF_VAR_OT_GCP_PROJECT"], env["TF_VAR_OT_GCP_ZONE"]); err != nil {
+			return "unknown url", fmt.Sprintf("error: %v", err)
+		}
+		return "unknown url", InstanceGone
+	}
 
-	rootURL := fmt.Sprintf("https://%s.%s", env["TF_VAR_OT_SUBDOMAIN_NAME"], env["TF_VAR_OT_DOMAIN_NAME"])
+	hostname := fmt.Sprintf("%s.%s", env["TF_VAR_OT_SUBDOMAIN_NAME"], env["TF_VAR_OT_DOMAIN_NAME"])
+	// Without a DNS zone the hostname depends on the address of the VM.
+	if env["TF_VAR_OT_DNS_MODE"] == "none" {
+		var err error
+		hostname, err = getInstanceHostname(env["TF_VAR_OT_GCP_PROJECT"], env["TF_VAR_OT_GCP_ZONE"], instanceName(env["TF_VAR_OT_SUBDOMAIN_NAME"]))
+		if err != nil {
+			return "unknown url", fmt.Sprintf("error: unable to get instance hostname: %v", err)
+		}
+	}
+	rootURL := fmt.Sprintf("https://%s", hostname)
 	url := fmt.Sprintf("%s/api/v4/graphql", rootURL)
 
+	if stateErr == nil && state != "" {
+		return rootURL, state
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
@@ -0,0 +1,145 @@
+package housekeeping
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/proto"
+)
+
+const gcpContextTimeout = 10 * time.Second
+
+// Instance states reported by CheckInstance when the VM is not running.
+const (
+	InstanceStopped   = "stopped"
+	InstancePreempted = "preempted"
+	// InstanceGone is reported when the VM no longer exists, e.g. after it
+	// deleted itself on expiry, while its config and workspace remain.
+	InstanceGone = "gone"
+)
+
+// instanceName returns the name of the VM of a cloud deployment.
+func instanceName(subdomain string) string {
+	return "devinstance-" + subdomain
+}
+
+// checkInstanceGone is called when the VM of a cloud deployment is not found,
+// and returns an error unless its project and zone can be looked up, so that a
+// wrong or deleted project or zone is not taken for a VM that is gone.
+func checkInstanceGone(project, zone string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	client, err := compute.NewZonesRESTClient(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	_, err = client.Get(ctx, &computepb.GetZoneRequest{Project: project, Zone: zone})
+	if err != nil {
+		return fmt.Errorf("vm not found, and zone %s of project %s cannot be looked up: %w", zone, project, err)
+	}
+	return nil
+}
+
+// getInstanceState returns InstanceStopped or InstancePreempted if the VM of a
+// cloud deployment is not running, and an empty string if it is.
+func getInstanceState(project, zone, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	client, err := compute.NewInstancesRESTClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	instance, err := client.Get(ctx, &computepb.GetInstanceRequest{
+		Project:  project,
+		Zone:     zone,
+		Instance: name,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch instance.GetStatus() {
+	case "RUNNING", "PROVISIONING", "STAGING":
+		return "", nil
+	}
+
+	preempted, err := wasPreempted(ctx, project, zone, instance)
+	if err != nil {
+		return "", err
+	}
+	if preempted {
+		return InstancePreempted, nil
+	}
+	return InstanceStopped, nil
+}
+
+// getInstanceHostname returns the hostname terraform worked out for the VM of a
+// cloud deployment, which it keeps in the instance metadata.
+func getInstanceHostname(project, zone, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	client, err := compute.NewInstancesRESTClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	instance, err := client.Get(ctx, &computepb.GetInstanceRequest{
+		Project:  project,
+		Zone:     zone,
+		Instance: name,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, item := range instance.GetMetadata().GetItems() {
+		if item.GetKey() == "hostname" {
+			return item.GetValue(), nil
+		}
+	}
+	return "", fmt.Errorf("instance %s has no hostname metadata", name)
+}
+
+// wasPreempted checks if an instance was preempted since it was last started.
+func wasPreempted(ctx context.Context, project, zone string, instance *computepb.Instance) (bool, error) {
+	client, err := compute.NewZoneOperationsRESTClient(ctx)
+	if err != nil {
+		return false, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	it := client.List(ctx, &computepb.ListZoneOperationsRequest{
+		Project: project,
+		Zone:    zone,
+		Filter:  proto.String(fmt.Sprintf(`(operationType = "compute.instances.preempted") AND (targetId = "%d")`, instance.GetId())),
+	})
+	for {
+		op, err := it.Next()
+		if err == iterator.Done {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		preemptedAt, err := time.Parse(time.RFC3339, op.GetInsertTime())
+		if err != nil {
+			continue
+		}
+		startedAt, err := time.Parse(time.RFC3339, instance.GetLastStartTimestamp())
+		if err != nil || preemptedAt.After(startedAt) {
+			return true, nil
+		}
+	}
+}
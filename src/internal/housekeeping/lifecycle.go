@@ -0,0 +1,50 @@
+package housekeeping
+
+import (
+	"context"
+	"fmt"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+)
+
+// StopCloud stops the VM of a cloud deployment. Disks, DNS records and the
+// config are kept, so it can be started again with StartCloud.
+func StopCloud(c *config.CloudDeploymentConfig) error {
+	client, err := compute.NewInstancesRESTClient(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	op, err := client.Stop(context.Background(), &computepb.StopInstanceRequest{
+		Project:  c.GCPProject.Value,
+		Zone:     c.GCPZone.Value,
+		Instance: instanceName(c.SubdomainName.Value),
+	})
+	if err := waitForOperation(op, err); err != nil {
+		return fmt.Errorf("error stopping instance %s: %w", instanceName(c.SubdomainName.Value), err)
+	}
+	return nil
+}
+
+// StartCloud starts the VM of a stopped cloud deployment. The startup script
+// brings the platform up again once it boots.
+func StartCloud(c *config.CloudDeploymentConfig) error {
+	client, err := compute.NewInstancesRESTClient(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	op, err := client.Start(context.Background(), &computepb.StartInstanceRequest{
+		Project:  c.GCPProject.Value,
+		Zone:     c.GCPZone.Value,
+		Instance: instanceName(c.SubdomainName.Value),
+	})
+	if err := waitForOperation(op, err); err != nil {
+		return fmt.Errorf("error starting instance %s: %w", instanceName(c.SubdomainName.Value), err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package housekeeping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// lockSuffix is appended to the config name of a deployment to name its lock.
+const lockSuffix = ".lock"
+
+// ExistingDeployment describes what is already stored in the ops URI under the
+// name of a deployment.
+type ExistingDeployment struct {
+	// Config is true if there is a config, at ConfigGeneration.
+	Config           bool
+	ConfigGeneration int64
+	// Workspace is true if there is a terraform workspace, which may be left
+	// from a deployment whose config was lost.
+	Workspace bool
+}
+
+// Exists returns true if anything is stored under the name.
+func (e *ExistingDeployment) Exists() bool {
+	return e.Config || e.Workspace
+}
+
+// FindExisting looks for the config and terraform workspace of a deployment in
+// its ops URI, to tell a new deployment from an update, and to keep a new one
+// from taking over another with the same name.
+func FindExisting(c *config.CloudDeploymentConfig) (*ExistingDeployment, error) {
+	existing := &ExistingDeployment{}
+
+	configFileURI := fmt.Sprintf("%s/%s", c.OpsURI.Value, c.SubdomainName.Value)
+	_, generation, err := tools.ReadFileFromGCSWithGeneration(configFileURI)
+	switch {
+	case err == nil:
+		existing.Config = true
+		existing.ConfigGeneration = generation
+	case !errors.Is(err, storage.ErrObjectNotExist):
+		return nil, fmt.Errorf("error reading config %s: %w", configFileURI, err)
+	}
+
+	// The gcs backend keeps the state of each workspace in <workspace>.tfstate.
+	stateFileURI := fmt.Sprintf("%s/%s.tfstate", c.OpsURI.Value, c.SubdomainName.Value)
+	_, err = tools.ReadFileFromGCS(stateFileURI)
+	switch {
+	case err == nil:
+		existing.Workspace = true
+	case !errors.Is(err, storage.ErrObjectNotExist):
+		return nil, fmt.Errorf("error reading state %s: %w", stateFileURI, err)
+	}
+
+	return existing, nil
+}
+
+// Locks are refreshed by their holder while it runs, and taken as stale, left by
+// a run that crashed or was interrupted, once they are not refreshed for a while.
+const (
+	lockRefreshInterval = time.Minute
+	lockStaleAfter      = 10 * time.Minute
+)
+
+// deploymentLock is the content of the lock of a deployment.
+type deploymentLock struct {
+	Holder    string    `json:"holder"`
+	Operation string    `json:"operation"`
+	CreatedAt time.Time `json:"created_at"`
+	// RefreshedAt is missing from the locks of older versions of the tool.
+	RefreshedAt time.Time `json:"refreshed_at,omitzero"`
+}
+
+// lockURI returns the URI of the lock of a deployment.
+func lockURI(opsURI, subdomain string) string {
+	return fmt.Sprintf("%s/%s%s", opsURI, subdomain, lockSuffix)
+}
+
+// stale returns true if the lock was not refreshed since lockStaleAfter.
+func (l *deploymentLock) stale(now time.Time) bool {
+	refreshedAt := l.RefreshedAt
+	if refreshedAt.IsZero() {
+		refreshedAt = l.CreatedAt
+	}
+	return now.Sub(refreshedAt) > lockStaleAfter
+}
+
+// readLock reads the lock of a deployment and its generation. It returns a nil
+// lock if there is none.
+func readLock(uri string) (*deploymentLock, int64, error) {
+	content, generation, err := tools.ReadFileFromGCSWithGeneration(uri)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading lock %s: %w", uri, err)
+	}
+	var l deploymentLock
+	if err := json.Unmarshal([]byte(content), &l); err != nil {
+		return nil, 0, fmt.Errorf("error parsing lock %s: %w", uri, err)
+	}
+	return &l, generation, nil
+}
+
+// Lock takes the lock of a deployment in its ops URI for an operation, so no
+// other run of the tool deploys or destroys it meanwhile. The lock is refreshed
+// until the returned function releases it, and a stale lock is taken over.
+func Lock(c *config.CloudDeploymentConfig, operation string) (func(), error) {
+	uri := lockURI(c.OpsURI.Value, c.SubdomainName.Value)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	lock := deploymentLock{
+		Holder:      currentIdentity(),
+		Operation:   operation,
+		CreatedAt:   now,
+		RefreshedAt: now,
+	}
+	content, err := json.Marshal(lock)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding lock: %w", err)
+	}
+
+	generation, err := tools.WriteFileToGCSAtGeneration(uri, string(content), 0)
+	if tools.IsPreconditionFailed(err) {
+		held, heldGeneration, readErr := readLock(uri)
+		if readErr != nil || held == nil {
+			return nil, fmt.Errorf("deployment %s is locked; if no other run of the tool is using it, delete %s", c.SubdomainName.Value, uri)
+		}
+		if !held.stale(now) {
+			return nil, fmt.Errorf("deployment %s is locked by %s, who is running %s since %s; the lock is released if it is not refreshed for %s",
+				c.SubdomainName.Value, held.Holder, held.Operation, held.CreatedAt.Local().Format("2006-01-02 15:04"), lockStaleAfter)
+		}
+		// Only one run can take over the stale lock at its generation.
+		generation, err = tools.WriteFileToGCSAtGeneration(uri, string(content), heldGeneration)
+		if tools.IsPreconditionFailed(err) {
+			return nil, fmt.Errorf("deployment %s was just locked by another run of the tool", c.SubdomainName.Value)
+		}
+		if err == nil {
+			log.Printf("taking over the stale lock of %s, left by %s running %s since %s", c.SubdomainName.Value, held.Holder, held.Operation, held.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error locking deployment %s: %w", c.SubdomainName.Value, err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			lock.RefreshedAt = time.Now().UTC().Truncate(time.Second)
+			content, _ := json.Marshal(lock)
+			g, err := tools.WriteFileToGCSAtGeneration(uri, string(content), generation)
+			if err != nil {
+				log.Printf("warning: unable to refresh the lock of %s: %v", c.SubdomainName.Value, err)
+				if tools.IsPreconditionFailed(err) {
+					// Another run took the lock over, it is not ours to refresh.
+					return
+				}
+				continue
+			}
+			generation = g
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		tools.DeleteFileFromGCSIfGeneration(uri, generation)
+	}, nil
+}
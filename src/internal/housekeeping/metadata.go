@@ -0,0 +1,154 @@
+package housekeeping
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// metadataSuffix is appended to the config name of a deployment to name its
+// metadata document. Names with a dot are not taken for configs.
+const metadataSuffix = ".meta.json"
+
+// Metadata describes a cloud deployment beyond its config: who created it and
+// when, when it expires, what the last deploy produced, and the configs it was
+// deployed with.
+type Metadata struct {
+	Name        string            `json:"name"`
+	Creator     string            `json:"creator"`
+	UpdatedBy   string            `json:"updated_by"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	ToolVersion string            `json:"tool_version"`
+	Outputs     map[string]string `json:"outputs"`
+	ConfigHash  string            `json:"config_hash"`
+	History     []ConfigVersion   `json:"history,omitempty"`
+}
+
+// withoutHistory returns a copy of the metadata without the config history, to
+// keep the registry small.
+func (m *Metadata) withoutHistory() *Metadata {
+	if m == nil {
+		return nil
+	}
+	summary := *m
+	summary.History = nil
+	return &summary
+}
+
+// metadataURI returns the GCS URI of the metadata document of a deployment.
+func metadataURI(opsURI, name string) string {
+	return fmt.Sprintf("%s/%s%s", opsURI, name, metadataSuffix)
+}
+
+// ReadMetadata reads the metadata document of a deployment. Deployments created
+// before documents were kept have none, which is reported as
+// storage.ErrObjectNotExist.
+func ReadMetadata(opsURI, name string) (*Metadata, error) {
+	content, err := tools.ReadFileFromGCS(metadataURI(opsURI, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var m Metadata
+	if err := json.Unmarshal([]byte(content), &m); err != nil {
+		return nil, fmt.Errorf("error parsing metadata of %s: %w", name, err)
+	}
+	return &m, nil
+}
+
+// writeMetadata creates or updates the metadata document of a deployment after
+// it is deployed with the given terraform outputs, and returns it. The creator,
+// creation time and config history of an existing document are kept, and the
+// config is added to the history if it changed.
+func writeMetadata(c *config.CloudDeploymentConfig, outputs map[string]string) (*Metadata, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	identity := currentIdentity()
+
+	m, err := ReadMetadata(c.OpsURI.Value, c.SubdomainName.Value)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		m = &Metadata{Creator: identity, CreatedAt: now}
+	} else if err != nil {
+		return nil, err
+	}
+
+	m.Name = c.SubdomainName.Value
+	m.UpdatedBy = identity
+	m.UpdatedAt = now
+	m.ExpiresAt = nil
+	if days, err := strconv.Atoi(c.DaysToLive.Value); err == nil && days > 0 {
+		expiresAt := m.CreatedAt.AddDate(0, 0, days)
+		m.ExpiresAt = &expiresAt
+	}
+	m.ToolVersion = toolVersion()
+	m.Outputs = outputs
+	m.ConfigHash = ConfigHash(c)
+	if err := recordVersion(m, c, identity, now); err != nil {
+		return nil, err
+	}
+
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding metadata: %w", err)
+	}
+	return m, tools.WriteFileToGCS(metadataURI(c.OpsURI.Value, c.SubdomainName.Value), string(content))
+}
+
+// ConfigHash returns the SHA-256 hash of a cloud deployment config as it is
+// uploaded, to tell whether the config of a deployment changed.
+func ConfigHash(c *config.CloudDeploymentConfig) string {
+	sum := sha256.Sum256([]byte(c.ToString()))
+	return hex.EncodeToString(sum[:])
+}
+
+// currentIdentity returns the account gcloud is authenticated with, or the
+// local user if it cannot be found.
+func currentIdentity() string {
+	out, err := exec.Command("gcloud", "config", "get-value", "account").Output()
+	if account := strings.TrimSpace(string(out)); err == nil && account != "" {
+		return account
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// toolVersion returns the version of the tool, from the module version or else
+// the revision it was built from.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+
+	version, modified := "devel", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			version = s.Value[:min(len(s.Value), 12)]
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified {
+		version += "-dirty"
+	}
+	return version
+}
@@ -0,0 +1,98 @@
+package housekeeping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"cloud.google.com/go/storage"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+)
+
+// configMetadataKey is the VM metadata key the config watcher reads the config
+// from.
+const configMetadataKey = "config"
+
+// PushConfig applies a config that only changes settings picked up by the
+// config watcher, without running terraform: it sets the config in the VM
+// metadata and uploads it to the ops URI, as a new version. The deployment is
+// locked meanwhile, and nothing is changed if it changed since existing was
+// found.
+func PushConfig(c *config.CloudDeploymentConfig, existing *ExistingDeployment) error {
+	unlock, err := Lock(c, "push")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := FindExisting(c)
+	if err != nil {
+		return err
+	}
+	if *current != *existing {
+		return fmt.Errorf("deployment %s was changed by someone else in the meantime", c.SubdomainName.Value)
+	}
+
+	if err := setInstanceConfig(c); err != nil {
+		return err
+	}
+
+	// The outputs do not change without a terraform run.
+	var outputs map[string]string
+	m, err := ReadMetadata(c.OpsURI.Value, c.SubdomainName.Value)
+	switch {
+	case err == nil:
+		outputs = m.Outputs
+	case !errors.Is(err, storage.ErrObjectNotExist):
+		return err
+	}
+	return UploadConfig(c, outputs, existing.ConfigGeneration)
+}
+
+// setInstanceConfig sets the config in the metadata of the VM of a deployment,
+// keeping the rest of its metadata.
+func setInstanceConfig(c *config.CloudDeploymentConfig) error {
+	client, err := compute.NewInstancesRESTClient(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	name := instanceName(c.SubdomainName.Value)
+	instance, err := client.Get(context.Background(), &computepb.GetInstanceRequest{
+		Project:  c.GCPProject.Value,
+		Zone:     c.GCPZone.Value,
+		Instance: name,
+	})
+	if err != nil {
+		return fmt.Errorf("error getting instance %s: %w", name, err)
+	}
+
+	metadata := instance.GetMetadata()
+	value := c.ToString()
+	found := false
+	for _, item := range metadata.GetItems() {
+		if item.GetKey() == configMetadataKey {
+			item.Value = &value
+			found = true
+		}
+	}
+	if !found {
+		key := configMetadataKey
+		metadata.Items = append(metadata.Items, &computepb.Items{Key: &key, Value: &value})
+	}
+
+	// The fingerprint in the metadata makes the update fail if it changed since.
+	op, err := client.SetMetadata(context.Background(), &computepb.SetMetadataInstanceRequest{
+		Project:          c.GCPProject.Value,
+		Zone:             c.GCPZone.Value,
+		Instance:         name,
+		MetadataResource: metadata,
+	})
+	if err := waitForOperation(op, err); err != nil {
+		return fmt.Errorf("error setting config of instance %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,642 @@
+package housekeeping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"cloud.google.com/go/storage"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kinds of orphaned resources found by Reap, in the order they are deleted.
+const (
+	OrphanInstance  = "instance"
+	OrphanSchedule  = "schedule"
+	OrphanDisk      = "disk"
+	OrphanSnapshot  = "snapshot"
+	OrphanFirewall  = "firewall"
+	OrphanAddress   = "address"
+	OrphanDNSRecord = "dns record"
+)
+
+// orphanKinds is the order orphans are deleted in, instances first so their
+// schedules, disks and addresses are no longer in use.
+var orphanKinds = []string{OrphanInstance, OrphanSchedule, OrphanDisk, OrphanSnapshot, OrphanFirewall, OrphanAddress, OrphanDNSRecord}
+
+// devinstanceFilter matches the names of the resources created by deployments.
+const devinstanceFilter = "name eq devinstance-.*"
+
+// opsLabelKey is the label terraform sets on the resources of a deployment to
+// the ops label of its ops URI (see config.OpsLabel), and deploymentLabelKey
+// the one it sets to its subdomain.
+const (
+	opsLabelKey        = "ops"
+	deploymentLabelKey = "deployment"
+)
+
+// archivedConfigPattern matches the names of archived configs, capturing the
+// subdomain of the deployment.
+var archivedConfigPattern = regexp.MustCompile(`^` + archiveFolder + `/(.+)-\d{8}T\d{6}Z$`)
+
+// ReapReport describes what Reap found, and what it did unless it was a dry run.
+type ReapReport struct {
+	DryRun           bool
+	DeleteUnlabelled bool
+	Expired          []ReapedDeployment
+	Orphans          []OrphanResource
+	Errors           []error
+}
+
+// ReapedDeployment is a deployment Reap destroys.
+type ReapedDeployment struct {
+	Name      string
+	ConfigURI string
+	Reason    string
+	Err       error
+}
+
+// OrphanResource is a GCP resource created by a deployment of an ops URI that
+// has no config, workspace or lock in it anymore.
+type OrphanResource struct {
+	Kind     string
+	Name     string
+	Project  string
+	Location string
+	// Deployment is the subdomain of the deployment the resource was created
+	// for.
+	Deployment string
+	// Unlabelled is true for resources named like those of deployments that
+	// carry no ops label, such as the ones created before resources were
+	// labelled. They may belong to another ops URI, so they are only deleted
+	// on request.
+	Unlabelled bool
+	Err        error
+}
+
+// dnsZone is a Cloud DNS zone deployments create records in.
+type dnsZone struct {
+	project string
+	zone    string
+	domain  string
+}
+
+// Reap destroys the deployments registered in an ops URI that have expired, or
+// whose VM is gone, through terraform. It then deletes the resources labelled
+// with the ops label of the ops URI whose deployment has no config, workspace
+// or lock in it anymore, in every project a config points to, along with their
+// instance schedule policies and DNS records, which take no labels. Unlabelled
+// devinstance- resources of such deployments are reported, and only deleted
+// with deleteUnlabelled. Deployments that are locked are left alone. With
+// dryRun nothing is destroyed or deleted, and the report shows what would be.
+// Terraform output is written to w if it is not nil.
+func Reap(opsURI string, dryRun, deleteUnlabelled bool, w io.Writer) (*ReapReport, error) {
+	report := &ReapReport{DryRun: dryRun, DeleteUnlabelled: deleteUnlabelled}
+
+	r, err := ReadRegistry(opsURI)
+	if err != nil {
+		return nil, fmt.Errorf("error reading registry: %w", err)
+	}
+
+	projects := map[string]bool{}
+	zones := map[dnsZone]bool{}
+	var live []*config.CloudDeploymentConfig
+	// Snapshots may be used by other deployments than the one they were made
+	// for, e.g. when a clone is cloned again without its data.
+	snapshotsInUse := map[string]bool{}
+	for _, e := range r.Entries() {
+		projects[e.Project] = true
+		c, err := config.NewCloudDeploymentConfig(e.Config)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("error loading config %s: %w", e.Config, err))
+			continue
+		}
+		if c.DNSMode.Value == "clouddns" {
+			zones[dnsZone{c.GCPProject.Value, c.GCPCloudDNSZone.Value, c.DomainName.Value}] = true
+		}
+		snapshotsInUse[c.SnapshotCH.Value] = true
+		snapshotsInUse[c.SnapshotOS.Value] = true
+		live = append(live, c)
+	}
+
+	// Archived configs are only used to find the projects and DNS zones used,
+	// and the deployments that existed.
+	archived := map[string]bool{}
+	files, err := tools.ListFilesInGCSPrefix(fmt.Sprintf("%s/%s", opsURI, archiveFolder))
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("error listing archived configs: %w", err))
+	}
+	for _, f := range files {
+		m := archivedConfigPattern.FindStringSubmatch(archiveFolder + "/" + f)
+		if m == nil {
+			continue
+		}
+		uri := fmt.Sprintf("%s/%s/%s", opsURI, archiveFolder, f)
+		c, err := config.NewCloudDeploymentConfig(uri)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("error loading config %s: %w", uri, err))
+			continue
+		}
+		projects[c.GCPProject.Value] = true
+		if c.DNSMode.Value == "clouddns" {
+			zones[dnsZone{c.GCPProject.Value, c.GCPCloudDNSZone.Value, c.DomainName.Value}] = true
+		}
+		archived[m[1]] = true
+	}
+
+	// 1. Expired deployments
+	for _, c := range live {
+		uri := fmt.Sprintf("%s/%s", opsURI, c.SubdomainName.Value)
+		locked, err := isLocked(opsURI, c.SubdomainName.Value)
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+			continue
+		}
+		if locked {
+			continue
+		}
+		reason, expired, err := checkExpiry(c, time.Now())
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("error checking expiry of %s: %w", c.SubdomainName.Value, err))
+			continue
+		}
+		if !expired {
+			continue
+		}
+
+		d := ReapedDeployment{Name: c.SubdomainName.Value, ConfigURI: uri, Reason: reason}
+		if !dryRun {
+			d.Err = DestroyCloud(uri, w)
+		}
+		report.Expired = append(report.Expired, d)
+	}
+
+	// 2. Orphaned resources, of deployments no longer claimed in the ops URI.
+	// Deployments missing from the registry may still be claimed, e.g. while
+	// they are first deployed.
+	claims := map[string]bool{}
+	claimed := func(s string) bool {
+		if _, ok := claims[s]; !ok {
+			c, err := isClaimed(opsURI, s)
+			if err != nil {
+				report.Errors = append(report.Errors, err)
+				// A deployment that cannot be checked is left alone.
+				c = true
+			}
+			claims[s] = c
+		}
+		return claims[s]
+	}
+	for _, e := range r.Entries() {
+		claims[e.Name] = true
+	}
+
+	label := config.OpsLabel(opsURI)
+	var orphans []OrphanResource
+	orphanSubdomains := map[string]bool{}
+	liveAddresses := map[string]bool{}
+	for _, project := range slices.Sorted(maps.Keys(projects)) {
+		found, addresses, err := findLabelled(project, label)
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+		}
+		for _, o := range found {
+			if o.Kind == OrphanSnapshot && snapshotsInUse[o.Name] {
+				continue
+			}
+			if s := o.Deployment; s != "" && !claimed(s) {
+				if !o.Unlabelled {
+					orphanSubdomains[s] = true
+				}
+				orphans = append(orphans, o)
+			}
+		}
+		for address, name := range addresses {
+			if !orphanSubdomains[deploymentSubdomain(name)] {
+				liveAddresses[address] = true
+			}
+		}
+	}
+
+	// Schedules and DNS records carry no label, so only the ones of orphaned
+	// deployments, or of deployments known to have existed, are considered,
+	// and DNS records never while they point to a VM that is not orphaned.
+	for s := range archived {
+		if !claimed(s) {
+			orphanSubdomains[s] = true
+		}
+	}
+	for _, project := range slices.Sorted(maps.Keys(projects)) {
+		found, err := findOrphanSchedules(project, orphanSubdomains, claimed)
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+		}
+		orphans = append(orphans, found...)
+	}
+	for z := range zones {
+		found, err := findOrphanRecords(z, orphanSubdomains, liveAddresses)
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+		}
+		orphans = append(orphans, found...)
+	}
+
+	slices.SortStableFunc(orphans, func(a, b OrphanResource) int {
+		return slices.Index(orphanKinds, a.Kind) - slices.Index(orphanKinds, b.Kind)
+	})
+	if !dryRun {
+		for i := range orphans {
+			if !orphans[i].Unlabelled || deleteUnlabelled {
+				orphans[i].Err = deleteOrphan(orphans[i])
+			}
+		}
+	}
+	report.Orphans = orphans
+
+	return report, nil
+}
+
+// DeploymentLabels returns the labels that tie a resource created outside of
+// terraform to a deployment of an ops URI, so that Reap deletes it once the
+// deployment is gone.
+func DeploymentLabels(opsURI, subdomain string) map[string]string {
+	return map[string]string{opsLabelKey: config.OpsLabel(opsURI), deploymentLabelKey: subdomain}
+}
+
+// isLocked returns true if a deployment holds a lock that is not stale.
+func isLocked(opsURI, name string) (bool, error) {
+	l, _, err := readLock(lockURI(opsURI, name))
+	if err != nil {
+		return false, err
+	}
+	return l != nil && !l.stale(time.Now()), nil
+}
+
+// isClaimed returns true if a deployment still has a config, a terraform
+// workspace or a lock in an ops URI.
+func isClaimed(opsURI, name string) (bool, error) {
+	for _, uri := range []string{fmt.Sprintf("%s/%s", opsURI, name), fmt.Sprintf("%s/%s.tfstate", opsURI, name)} {
+		_, err := tools.ReadFileFromGCS(uri)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, storage.ErrObjectNotExist) {
+			return false, fmt.Errorf("error reading %s: %w", uri, err)
+		}
+	}
+	return isLocked(opsURI, name)
+}
+
+// checkExpiry returns whether a deployment has expired, and why. Deployments
+// expire at the time in their metadata document, or when their days to live
+// have passed since their VM was created if they have none, and once their VM
+// is gone.
+func checkExpiry(c *config.CloudDeploymentConfig, now time.Time) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpContextTimeout)
+	defer cancel()
+
+	client, err := compute.NewInstancesRESTClient(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	instance, err := client.Get(ctx, &computepb.GetInstanceRequest{
+		Project:  c.GCPProject.Value,
+		Zone:     c.GCPZone.Value,
+		Instance: instanceName(c.SubdomainName.Value),
+	})
+	if status.Code(err) == codes.NotFound {
+		if err := checkInstanceGone(c.GCPProject.Value, c.GCPZone.Value); err != nil {
+			return "", false, err
+		}
+		return "vm is gone", true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	var expiresAt time.Time
+	m, err := ReadMetadata(c.OpsURI.Value, c.SubdomainName.Value)
+	switch {
+	case err == nil:
+		if m.ExpiresAt == nil {
+			return "", false, nil
+		}
+		expiresAt = *m.ExpiresAt
+	case errors.Is(err, storage.ErrObjectNotExist):
+		days, err := strconv.Atoi(c.DaysToLive.Value)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid days to live %q", c.DaysToLive.Value)
+		}
+		if days == 0 {
+			return "", false, nil
+		}
+		createdAt, err := time.Parse(time.RFC3339, instance.GetCreationTimestamp())
+		if err != nil {
+			return "", false, fmt.Errorf("invalid instance creation time: %w", err)
+		}
+		expiresAt = createdAt.AddDate(0, 0, days)
+	default:
+		return "", false, err
+	}
+
+	if now.Before(expiresAt) {
+		return "", false, nil
+	}
+	return fmt.Sprintf("expired on %s", expiresAt.Format("2006-01-02 15:04")), true, nil
+}
+
+// deploymentSubdomain returns the subdomain of the deployment a resource was
+// created for, from its name, or an empty string for snapshot builder resources.
+func deploymentSubdomain(name string) string {
+	name = strings.TrimPrefix(name, "devinstance-")
+	if strings.HasPrefix(name, "builder-") {
+		return ""
+	}
+	for _, prefix := range []string{"datavolume-ch-", "datavolume-os-", "allow-", "acme-", "schedule-"} {
+		if s, ok := strings.CutPrefix(name, prefix); ok {
+			return s
+		}
+	}
+	return name
+}
+
+// findLabelled returns the instances, disks, snapshots, firewall rules and
+// addresses of a project that carry the ops label, or its description for
+// firewall rules, along with the devinstance- ones that carry no ops label at
+// all, and the external addresses of every deployment VM in the project by VM
+// name.
+func findLabelled(project, label string) ([]OrphanResource, map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpOperationTimeout)
+	defer cancel()
+
+	var found []OrphanResource
+	addresses := map[string]string{}
+	add := func(kind, name, location string, labels map[string]string) {
+		ops, labelled := labels[opsLabelKey]
+		if labelled && ops != label {
+			// A resource of another ops URI.
+			return
+		}
+		deployment := labels[deploymentLabelKey]
+		if deployment == "" {
+			deployment = deploymentSubdomain(name)
+		}
+		found = append(found, OrphanResource{Kind: kind, Name: name, Project: project, Location: path.Base(location), Deployment: deployment, Unlabelled: !labelled})
+	}
+
+	instances, err := compute.NewInstancesRESTClient(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer instances.Close()
+	it := instances.AggregatedList(ctx, &computepb.AggregatedListInstancesRequest{Project: project, Filter: proto.String(devinstanceFilter)})
+	for {
+		pair, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return found, addresses, fmt.Errorf("error listing instances in %s: %w", project, err)
+		}
+		for _, i := range pair.Value.GetInstances() {
+			add(OrphanInstance, i.GetName(), i.GetZone(), i.GetLabels())
+			for _, n := range i.GetNetworkInterfaces() {
+				for _, ac := range n.GetAccessConfigs() {
+					if ip := ac.GetNatIP(); ip != "" {
+						addresses[ip] = i.GetName()
+					}
+				}
+			}
+		}
+	}
+
+	disks, err := compute.NewDisksRESTClient(ctx)
+	if err != nil {
+		return found, addresses, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer disks.Close()
+	dit := disks.AggregatedList(ctx, &computepb.AggregatedListDisksRequest{Project: project, Filter: proto.String(devinstanceFilter)})
+	for {
+		pair, err := dit.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return found, addresses, fmt.Errorf("error listing disks in %s: %w", project, err)
+		}
+		for _, d := range pair.Value.GetDisks() {
+			add(OrphanDisk, d.GetName(), d.GetZone(), d.GetLabels())
+		}
+	}
+
+	// Snapshots are not named after their deployment, such as the ones clones
+	// start from, so they are only found by label.
+	snapshots, err := compute.NewSnapshotsRESTClient(ctx)
+	if err != nil {
+		return found, addresses, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer snapshots.Close()
+	sit := snapshots.List(ctx, &computepb.ListSnapshotsRequest{Project: project, Filter: proto.String(fmt.Sprintf("labels.%s = %s", opsLabelKey, label))})
+	for {
+		sn, err := sit.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return found, addresses, fmt.Errorf("error listing snapshots in %s: %w", project, err)
+		}
+		if sn.GetLabels()[deploymentLabelKey] != "" {
+			add(OrphanSnapshot, sn.GetName(), "global", sn.GetLabels())
+		}
+	}
+
+	// Firewall rules take no labels, terraform puts the ops label in their
+	// description instead.
+	firewalls, err := compute.NewFirewallsRESTClient(ctx)
+	if err != nil {
+		return found, addresses, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer firewalls.Close()
+	fit := firewalls.List(ctx, &computepb.ListFirewallsRequest{Project: project, Filter: proto.String(devinstanceFilter)})
+	for {
+		f, err := fit.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return found, addresses, fmt.Errorf("error listing firewall rules in %s: %w", project, err)
+		}
+		var labels map[string]string
+		if _, ops, ok := strings.Cut(f.GetDescription(), ", ops label "); ok {
+			labels = map[string]string{opsLabelKey: ops}
+		}
+		add(OrphanFirewall, f.GetName(), "global", labels)
+	}
+
+	addressesClient, err := compute.NewAddressesRESTClient(ctx)
+	if err != nil {
+		return found, addresses, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer addressesClient.Close()
+	ait := addressesClient.AggregatedList(ctx, &computepb.AggregatedListAddressesRequest{Project: project, Filter: proto.String(devinstanceFilter)})
+	for {
+		pair, err := ait.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return found, addresses, fmt.Errorf("error listing addresses in %s: %w", project, err)
+		}
+		for _, a := range pair.Value.GetAddresses() {
+			add(OrphanAddress, a.GetName(), a.GetRegion(), a.GetLabels())
+		}
+	}
+
+	return found, addresses, nil
+}
+
+// findOrphanSchedules returns the instance schedule policies of a project for
+// the subdomains set in orphaned, and, as unlabelled, those of other
+// deployments that are not claimed.
+func findOrphanSchedules(project string, orphaned map[string]bool, claimed func(string) bool) ([]OrphanResource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpOperationTimeout)
+	defer cancel()
+
+	client, err := compute.NewResourcePoliciesRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer client.Close()
+
+	var orphans []OrphanResource
+	it := client.AggregatedList(ctx, &computepb.AggregatedListResourcePoliciesRequest{Project: project, Filter: proto.String(devinstanceFilter)})
+	for {
+		pair, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return orphans, fmt.Errorf("error listing resource policies in %s: %w", project, err)
+		}
+		for _, p := range pair.Value.GetResourcePolicies() {
+			s, ok := strings.CutPrefix(p.GetName(), "devinstance-schedule-")
+			if !ok || (!orphaned[s] && claimed(s)) {
+				continue
+			}
+			orphans = append(orphans, OrphanResource{Kind: OrphanSchedule, Name: p.GetName(), Project: project, Location: path.Base(p.GetRegion()), Deployment: s, Unlabelled: !orphaned[s]})
+		}
+	}
+	return orphans, nil
+}
+
+// findOrphanRecords returns the A records of a Cloud DNS zone for the
+// subdomains set in orphaned, unless they point to one of liveAddresses.
+func findOrphanRecords(z dnsZone, orphaned, liveAddresses map[string]bool) ([]OrphanResource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcpOperationTimeout)
+	defer cancel()
+
+	service, err := dns.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+
+	var orphans []OrphanResource
+	err = service.ResourceRecordSets.List(z.project, z.zone).Context(ctx).Pages(ctx, func(page *dns.ResourceRecordSetsListResponse) error {
+		for _, r := range page.Rrsets {
+			if r.Type != "A" || slices.ContainsFunc(r.Rrdatas, func(ip string) bool { return liveAddresses[ip] }) {
+				continue
+			}
+			s, ok := strings.CutSuffix(r.Name, "."+z.domain+".")
+			if ok && orphaned[s] {
+				orphans = append(orphans, OrphanResource{Kind: OrphanDNSRecord, Name: r.Name, Project: z.project, Location: z.zone})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing records in dns zone %s: %w", z.zone, err)
+	}
+	return orphans, nil
+}
+
+// deleteOrphan deletes an orphaned resource. Resources already gone, such as
+// boot disks deleted along their instance, are not an error.
+func deleteOrphan(o OrphanResource) error {
+	ctx := context.Background()
+
+	var err error
+	switch o.Kind {
+	case OrphanInstance:
+		var client *compute.InstancesClient
+		if client, err = compute.NewInstancesRESTClient(ctx); err == nil {
+			defer client.Close()
+			err = waitForOperation(client.Delete(ctx, &computepb.DeleteInstanceRequest{Project: o.Project, Zone: o.Location, Instance: o.Name}))
+		}
+	case OrphanSchedule:
+		var client *compute.ResourcePoliciesClient
+		if client, err = compute.NewResourcePoliciesRESTClient(ctx); err == nil {
+			defer client.Close()
+			err = waitForOperation(client.Delete(ctx, &computepb.DeleteResourcePolicyRequest{Project: o.Project, Region: o.Location, ResourcePolicy: o.Name}))
+		}
+	case OrphanDisk:
+		var client *compute.DisksClient
+		if client, err = compute.NewDisksRESTClient(ctx); err == nil {
+			defer client.Close()
+			err = waitForOperation(client.Delete(ctx, &computepb.DeleteDiskRequest{Project: o.Project, Zone: o.Location, Disk: o.Name}))
+		}
+	case OrphanSnapshot:
+		var client *compute.SnapshotsClient
+		if client, err = compute.NewSnapshotsRESTClient(ctx); err == nil {
+			defer client.Close()
+			err = waitForOperation(client.Delete(ctx, &computepb.DeleteSnapshotRequest{Project: o.Project, Snapshot: o.Name}))
+		}
+	case OrphanFirewall:
+		var client *compute.FirewallsClient
+		if client, err = compute.NewFirewallsRESTClient(ctx); err == nil {
+			defer client.Close()
+			err = waitForOperation(client.Delete(ctx, &computepb.DeleteFirewallRequest{Project: o.Project, Firewall: o.Name}))
+		}
+	case OrphanAddress:
+		var client *compute.AddressesClient
+		if client, err = compute.NewAddressesRESTClient(ctx); err == nil {
+			defer client.Close()
+			err = waitForOperation(client.Delete(ctx, &computepb.DeleteAddressRequest{Project: o.Project, Region: o.Location, Address: o.Name}))
+		}
+	case OrphanDNSRecord:
+		var service *dns.Service
+		if service, err = dns.NewService(ctx); err == nil {
+			_, err = service.ResourceRecordSets.Delete(o.Project, o.Location, o.Name, "A").Context(ctx).Do()
+		}
+	}
+
+	// The DNS client reports errors as googleapi errors, which carry no gRPC
+	// status.
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil
+	}
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("error deleting %s %s: %w", o.Kind, o.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package housekeeping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// registryFilename is the name of the registry object in an ops URI.
+const registryFilename = "registry.json"
+
+// registryUpdateAttempts is how many times an update of the registry is tried
+// when other updates keep getting in the way.
+const registryUpdateAttempts = 5
+
+// Registry is the index of the cloud deployments stored in an ops URI. It is
+// kept in a single object, so deployments are listed in one read, and updated
+// with generation preconditions, so concurrent updates do not overwrite each
+// other.
+type Registry struct {
+	Deployments map[string]RegistryEntry `json:"deployments"`
+}
+
+// RegistryEntry is a deployment in the registry, with what is needed to check
+// its instance without reading its config.
+type RegistryEntry struct {
+	Name     string    `json:"name"`
+	Config   string    `json:"config"`
+	Project  string    `json:"project"`
+	Zone     string    `json:"zone"`
+	Domain   string    `json:"domain"`
+	DNSMode  string    `json:"dns_mode"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// Entries returns the deployments in the registry sorted by name.
+func (r *Registry) Entries() []RegistryEntry {
+	entries := make([]RegistryEntry, 0, len(r.Deployments))
+	for _, name := range slices.Sorted(maps.Keys(r.Deployments)) {
+		entries = append(entries, r.Deployments[name])
+	}
+	return entries
+}
+
+// CheckInstance checks the state of the instance of a registered deployment.
+func (e RegistryEntry) CheckInstance() (string, string) {
+	return checkInstance(map[string]string{
+		"TF_VAR_OT_GCP_PROJECT":    e.Project,
+		"TF_VAR_OT_GCP_ZONE":       e.Zone,
+		"TF_VAR_OT_SUBDOMAIN_NAME": e.Name,
+		"TF_VAR_OT_DOMAIN_NAME":    e.Domain,
+		"TF_VAR_OT_DNS_MODE":       e.DNSMode,
+	})
+}
+
+// registryURI returns the GCS URI of the registry of an ops URI.
+func registryURI(opsURI string) string {
+	return fmt.Sprintf("%s/%s", opsURI, registryFilename)
+}
+
+// newRegistryEntry returns the registry entry of a deployment.
+func newRegistryEntry(c *config.CloudDeploymentConfig, m *Metadata) RegistryEntry {
+	return RegistryEntry{
+		Name:     c.SubdomainName.Value,
+		Config:   fmt.Sprintf("%s/%s", c.OpsURI.Value, c.SubdomainName.Value),
+		Project:  c.GCPProject.Value,
+		Zone:     c.GCPZone.Value,
+		Domain:   c.DomainName.Value,
+		DNSMode:  c.DNSMode.Value,
+		Metadata: m.withoutHistory(),
+	}
+}
+
+// ReadRegistry reads the registry of an ops URI. An ops URI without one holds
+// the flat configs of older versions of the tool, which are migrated into a new
+// registry.
+func ReadRegistry(opsURI string) (*Registry, error) {
+	r, generation, err := readRegistry(opsURI)
+	if err != nil || generation != 0 {
+		return r, err
+	}
+
+	// Keep the migrated registry, unless another migration got there first.
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding registry: %w", err)
+	}
+	err = tools.WriteFileToGCSIfGeneration(registryURI(opsURI), string(content), 0)
+	if err != nil && !tools.IsPreconditionFailed(err) {
+		return nil, fmt.Errorf("error writing registry: %w", err)
+	}
+	return r, nil
+}
+
+// readRegistry reads the registry of an ops URI along with its generation, or
+// migrates the flat configs into a registry with generation 0 if there is none.
+func readRegistry(opsURI string) (*Registry, int64, error) {
+	content, generation, err := tools.ReadFileFromGCSWithGeneration(registryURI(opsURI))
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		r, err := migrateRegistry(opsURI)
+		return r, 0, err
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading registry: %w", err)
+	}
+
+	r := &Registry{}
+	if err := json.Unmarshal([]byte(content), r); err != nil {
+		return nil, 0, fmt.Errorf("error parsing registry: %w", err)
+	}
+	if r.Deployments == nil {
+		r.Deployments = map[string]RegistryEntry{}
+	}
+	return r, generation, nil
+}
+
+// migrateRegistry builds a registry from the configs stored flat in an ops URI,
+// named after their subdomain, and their metadata documents.
+func migrateRegistry(opsURI string) (*Registry, error) {
+	r := &Registry{Deployments: map[string]RegistryEntry{}}
+
+	files, err := tools.ListFilesInGCSPrefix(opsURI)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files in ops uri: %w", err)
+	}
+	for _, f := range files {
+		if strings.ContainsAny(f, "./") {
+			continue
+		}
+		content, err := tools.ReadFileFromGCS(fmt.Sprintf("%s/%s", opsURI, f))
+		if err != nil {
+			return nil, fmt.Errorf("error reading config %s: %w", f, err)
+		}
+		env, err := tools.ParseEnv(content)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing config %s: %w", f, err)
+		}
+		// Files that are not deployment configs are not indexed.
+		if env["OT_DEPLOYMENT_TYPE"] != "cloud" {
+			continue
+		}
+
+		m, err := ReadMetadata(opsURI, f)
+		if err != nil {
+			m = nil
+		}
+		r.Deployments[f] = RegistryEntry{
+			Name:     f,
+			Config:   fmt.Sprintf("%s/%s", opsURI, f),
+			Project:  env["TF_VAR_OT_GCP_PROJECT"],
+			Zone:     env["TF_VAR_OT_GCP_ZONE"],
+			Domain:   env["TF_VAR_OT_DOMAIN_NAME"],
+			DNSMode:  env["TF_VAR_OT_DNS_MODE"],
+			Metadata: m.withoutHistory(),
+		}
+	}
+	return r, nil
+}
+
+// updateRegistry applies update to the registry of an ops URI, and writes it if
+// nobody else wrote it since it was read. Otherwise it is read and updated
+// again.
+func updateRegistry(opsURI string, update func(r *Registry)) error {
+	for range registryUpdateAttempts {
+		r, generation, err := readRegistry(opsURI)
+		if err != nil {
+			return err
+		}
+		update(r)
+
+		content, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding registry: %w", err)
+		}
+		err = tools.WriteFileToGCSIfGeneration(registryURI(opsURI), string(content), generation)
+		if tools.IsPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error writing registry: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("error writing registry: still updated by others after %d attempts", registryUpdateAttempts)
+}
+
+// register adds or updates a deployment in the registry of its ops URI.
+func register(c *config.CloudDeploymentConfig, m *Metadata) error {
+	entry := newRegistryEntry(c, m)
+	return updateRegistry(c.OpsURI.Value, func(r *Registry) {
+		r.Deployments[entry.Name] = entry
+	})
+}
+
+// unregister removes a deployment from the registry of its ops URI.
+func unregister(c *config.CloudDeploymentConfig) error {
+	return updateRegistry(c.OpsURI.Value, func(r *Registry) {
+		delete(r.Deployments, c.SubdomainName.Value)
+	})
+}
@@ -0,0 +1,385 @@
+package housekeeping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// gcpOperationTimeout is how long to wait for a compute operation, such as
+	// creating a disk or a snapshot.
+	gcpOperationTimeout = 30 * time.Minute
+	// snapshotBuildTimeout is how long the builder VM has to populate the disks.
+	snapshotBuildTimeout = 3 * time.Hour
+	// snapshotBuildPollInterval is how often the builder VM is checked.
+	snapshotBuildPollInterval = 30 * time.Second
+)
+
+// snapshotKinds are the data disks of a deployment, by snapshot kind.
+var snapshotKinds = []struct {
+	kind   string
+	engine string
+}{
+	{config.SnapshotKindClickHouse, config.EngineClickHouse},
+	{config.SnapshotKindOpenSearch, config.EngineOpenSearch},
+}
+
+// SnapshotBuild holds the settings to build the data snapshots of a release.
+type SnapshotBuild struct {
+	Project        string
+	Zone           string
+	Network        string
+	ServiceAccount string
+	MachineType    string
+	Release        string
+	ReleaseURL     string
+	DiskSizeGB     int64
+}
+
+// builderName returns the name of the builder VM, which is also the prefix of
+// its disks. It starts with devinstance- so the deployment service account can
+// use it.
+func (b *SnapshotBuild) builderName() string {
+	return fmt.Sprintf("devinstance-builder-%s", config.ReleaseLabel(b.Release))
+}
+
+// region returns the region of the build zone.
+func (b *SnapshotBuild) region() string {
+	return b.Zone[:max(strings.LastIndex(b.Zone, "-"), 0)]
+}
+
+// CreateSnapshots builds the data snapshots of a release. It creates blank
+// disks and a builder VM that populates them from the release tarballs, then
+// snapshots the disks with release and engine labels. The builder VM and its
+// disks are deleted whatever the outcome. Progress is written to w. It returns
+// the names of the snapshots.
+func CreateSnapshots(b *SnapshotBuild, w io.Writer) ([]string, error) {
+	ctx := context.Background()
+
+	instances, err := compute.NewInstancesRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer instances.Close()
+
+	disks, err := compute.NewDisksRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer disks.Close()
+
+	snapshots, err := compute.NewSnapshotsRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer snapshots.Close()
+
+	// Fail before building anything if the snapshots are already there.
+	var names []string
+	for _, k := range snapshotKinds {
+		name := config.SnapshotName(b.Release, k.kind)
+		_, err := snapshots.Get(ctx, &computepb.GetSnapshotRequest{Project: b.Project, Snapshot: name})
+		if err == nil {
+			return nil, fmt.Errorf("snapshot %s already exists", name)
+		}
+		if status.Code(err) != codes.NotFound {
+			return nil, fmt.Errorf("error looking up snapshot %s: %w", name, err)
+		}
+		names = append(names, name)
+	}
+
+	labels := map[string]string{
+		"team":    "opentargets",
+		"product": "platform",
+		"tool":    "standalone",
+		"release": config.ReleaseLabel(b.Release),
+	}
+
+	defer teardownBuilder(instances, disks, b, w)
+
+	for _, k := range snapshotKinds {
+		diskName := fmt.Sprintf("%s-%s", b.builderName(), k.kind)
+		fmt.Fprintf(w, "creating %s disk %s\n", k.engine, diskName)
+		op, err := disks.Insert(ctx, &computepb.InsertDiskRequest{
+			Project: b.Project,
+			Zone:    b.Zone,
+			DiskResource: &computepb.Disk{
+				Name:   proto.String(diskName),
+				SizeGb: proto.Int64(b.DiskSizeGB),
+				Type:   proto.String(fmt.Sprintf("zones/%s/diskTypes/pd-balanced", b.Zone)),
+				Labels: labels,
+			},
+		})
+		if err := waitForOperation(op, err); err != nil {
+			return nil, fmt.Errorf("error creating disk %s: %w", diskName, err)
+		}
+	}
+
+	script, err := os.ReadFile("./etc/snapshot-builder.sh")
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot builder script: %w", err)
+	}
+
+	fmt.Fprintf(w, "creating builder vm %s\n", b.builderName())
+	attachedDisks := []*computepb.AttachedDisk{{
+		Boot:       proto.Bool(true),
+		AutoDelete: proto.Bool(true),
+		InitializeParams: &computepb.AttachedDiskInitializeParams{
+			SourceImage: proto.String("projects/debian-cloud/global/images/family/debian-12"),
+			DiskSizeGb:  proto.Int64(20),
+		},
+	}}
+	for _, k := range snapshotKinds {
+		attachedDisks = append(attachedDisks, &computepb.AttachedDisk{
+			Source:     proto.String(fmt.Sprintf("projects/%s/zones/%s/disks/%s-%s", b.Project, b.Zone, b.builderName(), k.kind)),
+			DeviceName: proto.String("datavolume-" + k.kind),
+		})
+	}
+	op, err := instances.Insert(ctx, &computepb.InsertInstanceRequest{
+		Project: b.Project,
+		Zone:    b.Zone,
+		InstanceResource: &computepb.Instance{
+			Name:        proto.String(b.builderName()),
+			MachineType: proto.String(fmt.Sprintf("zones/%s/machineTypes/%s", b.Zone, b.MachineType)),
+			Disks:       attachedDisks,
+			NetworkInterfaces: []*computepb.NetworkInterface{{
+				Network:    proto.String(fmt.Sprintf("projects/%s/global/networks/%s", b.Project, b.Network)),
+				Subnetwork: proto.String(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", b.Project, b.region(), b.Network)),
+				AccessConfigs: []*computepb.AccessConfig{{
+					Name: proto.String("External NAT"),
+					Type: proto.String("ONE_TO_ONE_NAT"),
+				}},
+			}},
+			ServiceAccounts: []*computepb.ServiceAccount{{
+				Email:  proto.String(b.ServiceAccount),
+				Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"},
+			}},
+			Metadata: &computepb.Metadata{Items: []*computepb.Items{
+				{Key: proto.String("startup-script"), Value: proto.String(string(script))},
+				{Key: proto.String("release"), Value: proto.String(b.Release)},
+				{Key: proto.String("release-url"), Value: proto.String(b.ReleaseURL)},
+			}},
+			Labels: labels,
+		},
+	})
+	if err := waitForOperation(op, err); err != nil {
+		return nil, fmt.Errorf("error creating builder vm: %w", err)
+	}
+
+	fmt.Fprintln(w, "populating disks, this may take a while...")
+	if err := waitForBuilder(instances, b); err != nil {
+		return nil, err
+	}
+
+	for i, k := range snapshotKinds {
+		diskName := fmt.Sprintf("%s-%s", b.builderName(), k.kind)
+		snapshotLabels := maps.Clone(labels)
+		snapshotLabels["engine"] = k.engine
+		fmt.Fprintf(w, "creating snapshot %s\n", names[i])
+		err := createSnapshot(snapshots, b.Project, b.Zone, diskName, names[i],
+			fmt.Sprintf("Open Targets Platform %s %s data", b.Release, k.engine), snapshotLabels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+// SaveSnapshots snapshots the data disks of a cloud deployment as <name>-ch and
+// <name>-os, labelled with the deployment they come from and its release, and
+// with extra labels if any. The compose stack on the VM is stopped while the disks are snapshotted, so the
+// data is consistent, and started again afterwards whatever the outcome.
+// Progress is written to w. It returns the names of the snapshots.
+func SaveSnapshots(c *config.CloudDeploymentConfig, name string, extra map[string]string, w io.Writer) ([]string, error) {
+	ctx := context.Background()
+
+	snapshots, err := compute.NewSnapshotsRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access google cloud: %w", err)
+	}
+	defer snapshots.Close()
+
+	var names []string
+	for _, k := range snapshotKinds {
+		snapshotName := fmt.Sprintf("%s-%s", name, k.kind)
+		if err := config.ValidateGCPResource(snapshotName); err != nil {
+			return nil, fmt.Errorf("invalid snapshot name %s: %w", snapshotName, err)
+		}
+		_, err := snapshots.Get(ctx, &computepb.GetSnapshotRequest{Project: c.GCPProject.Value, Snapshot: snapshotName})
+		if err == nil {
+			return nil, fmt.Errorf("snapshot %s already exists", snapshotName)
+		}
+		if status.Code(err) != codes.NotFound {
+			return nil, fmt.Errorf("error looking up snapshot %s: %w", snapshotName, err)
+		}
+		names = append(names, snapshotName)
+	}
+
+	fmt.Fprintln(w, "stopping the platform")
+	if err := runOnInstance(c, "sudo docker compose -f /platform/compose.yaml stop", w); err != nil {
+		return nil, fmt.Errorf("error stopping the platform: %w", err)
+	}
+	defer func() {
+		// start reuses the stopped containers, so the environment they were
+		// created with does not need to be loaded again.
+		fmt.Fprintln(w, "starting the platform")
+		if err := runOnInstance(c, "sudo docker compose -f /platform/compose.yaml start", w); err != nil {
+			fmt.Fprintf(w, "error starting the platform, restart the vm to recover: %v\n", err)
+		}
+	}()
+
+	labels := map[string]string{
+		"team":    "opentargets",
+		"product": "platform",
+		"tool":    "standalone",
+		"origin":  c.SubdomainName.Value,
+		"release": config.ReleaseLabel(c.Release.Value),
+	}
+	maps.Copy(labels, extra)
+	for i, k := range snapshotKinds {
+		diskName := fmt.Sprintf("devinstance-datavolume-%s-%s", k.kind, c.SubdomainName.Value)
+		snapshotLabels := maps.Clone(labels)
+		snapshotLabels["engine"] = k.engine
+		fmt.Fprintf(w, "creating snapshot %s from %s\n", names[i], diskName)
+		err := createSnapshot(snapshots, c.GCPProject.Value, c.GCPZone.Value, diskName, names[i],
+			fmt.Sprintf("Open Targets Platform %s %s data saved from %s", c.Release.Value, k.engine, c.SubdomainName.Value), snapshotLabels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+// runOnInstance runs a command on the VM of a cloud deployment over ssh. The
+// deployment firewall rules only open the web ports, so ssh is tunnelled
+// through IAP, which the bootstrap firewall rule lets in.
+func runOnInstance(c *config.CloudDeploymentConfig, command string, w io.Writer) error {
+	cmd := exec.Command("gcloud", "compute", "ssh", instanceName(c.SubdomainName.Value),
+		"--project", c.GCPProject.Value,
+		"--zone", c.GCPZone.Value,
+		"--tunnel-through-iap",
+		"--quiet",
+		"--command", command,
+	)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
+// waitForBuilder waits until the builder VM powers itself off, and checks the
+// result it reported on the serial console.
+func waitForBuilder(instances *compute.InstancesClient, b *SnapshotBuild) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotBuildTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("builder vm did not finish in %s", snapshotBuildTimeout)
+		case <-time.After(snapshotBuildPollInterval):
+		}
+
+		instance, err := instances.Get(ctx, &computepb.GetInstanceRequest{
+			Project:  b.Project,
+			Zone:     b.Zone,
+			Instance: b.builderName(),
+		})
+		if err != nil {
+			return fmt.Errorf("error checking builder vm: %w", err)
+		}
+		if instance.GetStatus() != "TERMINATED" {
+			continue
+		}
+
+		output, err := instances.GetSerialPortOutput(ctx, &computepb.GetSerialPortOutputInstanceRequest{
+			Project:  b.Project,
+			Zone:     b.Zone,
+			Instance: b.builderName(),
+			Port:     proto.Int32(1),
+		})
+		if err != nil {
+			return fmt.Errorf("error reading builder vm output: %w", err)
+		}
+
+		for _, line := range strings.Split(output.GetContents(), "\n") {
+			if _, reason, failed := strings.Cut(line, "snapshot-builder: failed: "); failed {
+				return fmt.Errorf("builder vm failed %s", strings.TrimSpace(reason))
+			}
+			if strings.Contains(line, "snapshot-builder: done") {
+				return nil
+			}
+		}
+		return fmt.Errorf("builder vm stopped without finishing")
+	}
+}
+
+// teardownBuilder deletes the builder VM and its disks. Errors are reported to
+// w, as there is nothing else to do about them.
+func teardownBuilder(instances *compute.InstancesClient, disks *compute.DisksClient, b *SnapshotBuild, w io.Writer) {
+	ctx := context.Background()
+
+	fmt.Fprintf(w, "deleting builder vm %s\n", b.builderName())
+	op, err := instances.Delete(ctx, &computepb.DeleteInstanceRequest{
+		Project:  b.Project,
+		Zone:     b.Zone,
+		Instance: b.builderName(),
+	})
+	if err := waitForOperation(op, err); err != nil && status.Code(err) != codes.NotFound {
+		fmt.Fprintf(w, "error deleting builder vm %s: %v\n", b.builderName(), err)
+	}
+
+	for _, k := range snapshotKinds {
+		diskName := fmt.Sprintf("%s-%s", b.builderName(), k.kind)
+		op, err := disks.Delete(ctx, &computepb.DeleteDiskRequest{
+			Project: b.Project,
+			Zone:    b.Zone,
+			Disk:    diskName,
+		})
+		if err := waitForOperation(op, err); err != nil && status.Code(err) != codes.NotFound {
+			fmt.Fprintf(w, "error deleting disk %s: %v\n", diskName, err)
+		}
+	}
+}
+
+// createSnapshot snapshots a disk and waits for the snapshot to be ready.
+func createSnapshot(snapshots *compute.SnapshotsClient, project, zone, disk, name, description string, labels map[string]string) error {
+	op, err := snapshots.Insert(context.Background(), &computepb.InsertSnapshotRequest{
+		Project: project,
+		SnapshotResource: &computepb.Snapshot{
+			Name:        proto.String(name),
+			Description: proto.String(description),
+			SourceDisk:  proto.String(fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, disk)),
+			Labels:      labels,
+		},
+	})
+	if err := waitForOperation(op, err); err != nil {
+		return fmt.Errorf("error creating snapshot %s: %w", name, err)
+	}
+	return nil
+}
+
+// waitForOperation waits for a compute operation to finish, taking the result of
+// the call that started it.
+func waitForOperation(op *compute.Operation, err error) error {
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gcpOperationTimeout)
+	defer cancel()
+	return op.Wait(ctx)
+}
@@ -0,0 +1,148 @@
+package housekeeping
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hc-install/product"
+	"github.com/hashicorp/hc-install/releases"
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+)
+
+// terraformVarsFilename is the name of the variables file terraform loads
+// automatically from the deployment directory.
+const terraformVarsFilename = "terraform.tfvars.json"
+
+// writeTerraformVars writes terraform input variables into a directory, so they
+// do not need to be passed through the process environment.
+func writeTerraformVars(dir string, v map[string]string) error {
+	vars, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding terraform variables: %w", err)
+	}
+
+	varsFilePath := filepath.Join(dir, terraformVarsFilename)
+	if err := os.WriteFile(varsFilePath, vars, 0644); err != nil {
+		return fmt.Errorf("error writing terraform variables file %s: %w", varsFilePath, err)
+	}
+	return nil
+}
+
+// commandEnv returns the process environment with env added on top, to be used
+// as the environment of a subprocess.
+func commandEnv(env map[string]string) []string {
+	environ := os.Environ()
+	for k, v := range env {
+		environ = append(environ, k+"="+v)
+	}
+	return environ
+}
+
+// newTerraform installs terraform and returns an instance initialized against
+// the deployment's backend, with the deployment workspace selected and its
+// variables file written. Terraform output goes to a timestamped log file in
+// the deployment directory and, if not nil, to w. The returned function closes
+// the log file.
+func newTerraform(c *config.CloudDeploymentConfig, w io.Writer) (*tfexec.Terraform, func(), error) {
+	if err := writeTerraformVars(c.GetDeploymentDir(), c.GetTerraformVars()); err != nil {
+		return nil, nil, err
+	}
+
+	tf, closeLog, err := installTerraform(c.GetDeploymentDir(), w)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(c.OpsURI.Value, "gs://"), "/", 2)
+	if len(parts) < 2 {
+		closeLog()
+		return nil, nil, fmt.Errorf("invalid ops uri: %s", c.OpsURI.Value)
+	}
+	bucket := fmt.Sprintf("bucket=%s", parts[0])
+	prefix := fmt.Sprintf("prefix=%s", parts[1])
+
+	err = tf.Init(
+		context.Background(),
+		tfexec.Upgrade(true),
+		tfexec.BackendConfig(bucket),
+		tfexec.BackendConfig(prefix),
+	)
+	if err != nil {
+		closeLog()
+		return nil, nil, fmt.Errorf("error initializing terraform: %w", err)
+	}
+
+	err = tf.WorkspaceSelect(context.Background(), c.SubdomainName.Value)
+	if err != nil {
+		err = tf.WorkspaceNew(context.Background(), c.SubdomainName.Value)
+		if err != nil {
+			closeLog()
+			return nil, nil, fmt.Errorf("error selecting or creating workspace: %w", err)
+		}
+	}
+
+	return tf, closeLog, nil
+}
+
+// installTerraform installs terraform and returns an instance working in dir.
+// Terraform output goes to a timestamped log file in dir and, if not nil, to w.
+// The returned function closes the log file.
+func installTerraform(dir string, w io.Writer) (*tfexec.Terraform, func(), error) {
+	logFilename := fmt.Sprintf("terraform-%s.log", time.Now().Format("2006-01-02-150405"))
+	logFilepath := filepath.Join(dir, logFilename)
+	logFile, err := os.Create(logFilepath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening log file %s: %w", logFilepath, err)
+	}
+
+	var out io.Writer = logFile
+	if w != nil {
+		out = io.MultiWriter(logFile, w)
+	}
+
+	installer := &releases.LatestVersion{
+		Product: product.Terraform,
+	}
+
+	execPath, err := installer.Install(context.Background())
+	if err != nil {
+		logFile.Close()
+		return nil, nil, fmt.Errorf("error installing terraform: %w", err)
+	}
+
+	tf, err := tfexec.NewTerraform(dir, execPath)
+	if err != nil {
+		logFile.Close()
+		return nil, nil, fmt.Errorf("error creating terraform instance: %w", err)
+	}
+
+	tf.SetStderr(out)
+	tf.SetStdout(out)
+
+	return tf, func() { logFile.Close() }, nil
+}
+
+// terraformOutputs returns the string outputs of the deployment workspace.
+func terraformOutputs(tf *tfexec.Terraform) (map[string]string, error) {
+	meta, err := tf.Output(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error reading terraform outputs: %w", err)
+	}
+
+	outputs := make(map[string]string, len(meta))
+	for name, m := range meta {
+		var v string
+		if err := json.Unmarshal(m.Value, &v); err != nil {
+			continue
+		}
+		outputs[name] = v
+	}
+	return outputs, nil
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// JobStatus is the state of an asynchronous job.
+type JobStatus string
+
+// Job states.
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is an asynchronous operation on a deployment, such as a deploy or a destroy.
+type Job struct {
+	mu         sync.Mutex
+	id         string
+	kind       string
+	deployment string
+	status     JobStatus
+	err        string
+	createdAt  time.Time
+	finishedAt time.Time
+	logs       bytes.Buffer
+}
+
+// JobView is the JSON representation of a Job.
+type JobView struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Deployment string     `json:"deployment"`
+	Status     JobStatus  `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Logs       string     `json:"logs,omitempty"`
+}
+
+// Write appends to the job logs, so a Job can be used as terraform output.
+func (j *Job) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.logs.Write(p)
+}
+
+// Logf appends a formatted line to the job logs.
+func (j *Job) Logf(format string, args ...any) {
+	fmt.Fprintf(j, format+"\n", args...)
+}
+
+// View returns a snapshot of the job, including its logs if withLogs is true.
+func (j *Job) View(withLogs bool) JobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	v := JobView{
+		ID:         j.id,
+		Kind:       j.kind,
+		Deployment: j.deployment,
+		Status:     j.status,
+		Error:      j.err,
+		CreatedAt:  j.createdAt,
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		v.FinishedAt = &finishedAt
+	}
+	if withLogs {
+		v.Logs = j.logs.String()
+	}
+	return v
+}
+
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.finishedAt = time.Now()
+	if err != nil {
+		j.status = JobFailed
+		j.err = err.Error()
+		fmt.Fprintf(&j.logs, "error: %v\n", err)
+		return
+	}
+	j.status = JobSucceeded
+}
+
+// JobManager keeps track of jobs and makes sure only one job runs per deployment.
+type JobManager struct {
+	mu     sync.Mutex
+	next   int
+	jobs   map[string]*Job
+	order  []string
+	active map[string]string
+}
+
+// NewJobManager creates an empty JobManager.
+func NewJobManager() *JobManager {
+	return &JobManager{
+		jobs:   map[string]*Job{},
+		active: map[string]string{},
+	}
+}
+
+// Start runs action in the background as a new job for deployment. It fails if
+// another job is already running for the same deployment.
+func (m *JobManager) Start(kind, deployment string, action func(j *Job) error) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id, busy := m.active[deployment]; busy {
+		return nil, fmt.Errorf("deployment %s is busy with job %s", deployment, id)
+	}
+
+	m.next++
+	j := &Job{
+		id:         fmt.Sprintf("%d", m.next),
+		kind:       kind,
+		deployment: deployment,
+		status:     JobRunning,
+		createdAt:  time.Now(),
+	}
+	m.jobs[j.id] = j
+	m.order = append(m.order, j.id)
+	m.active[deployment] = j.id
+
+	go func() {
+		log.Printf("job %s: %s %s started", j.id, kind, deployment)
+		err := action(j)
+		j.finish(err)
+
+		m.mu.Lock()
+		delete(m.active, deployment)
+		m.mu.Unlock()
+
+		log.Printf("job %s: %s %s finished: %s", j.id, kind, deployment, j.View(false).Status)
+	}()
+
+	return j, nil
+}
+
+// Get returns the job with the given id.
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+// List returns all jobs, oldest first.
+func (m *JobManager) List() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*Job, 0, len(m.order))
+	for _, id := range m.order {
+		jobs = append(jobs, m.jobs[id])
+	}
+	return jobs
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitFor waits for a job to finish, and fails the test if it does not.
+func waitFor(t *testing.T, j *Job) JobView {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if v := j.View(true); v.Status != JobRunning {
+			return v
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", j.id)
+	return JobView{}
+}
+
+func TestJobManagerStart(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status JobStatus
+		logs   string
+	}{
+		{"success", nil, JobSucceeded, "working\n"},
+		{"failure", errors.New("boom"), JobFailed, "working\nerror: boom\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewJobManager()
+			j, err := m.Start("deploy", "dev", func(j *Job) error {
+				j.Logf("working")
+				return tt.err
+			})
+			if err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+
+			v := waitFor(t, j)
+			if v.Status != tt.status {
+				t.Errorf("status = %s, want %s", v.Status, tt.status)
+			}
+			if v.Logs != tt.logs {
+				t.Errorf("logs = %q, want %q", v.Logs, tt.logs)
+			}
+			if v.FinishedAt == nil {
+				t.Error("finished_at is not set")
+			}
+			if tt.err != nil && v.Error != tt.err.Error() {
+				t.Errorf("error = %q, want %q", v.Error, tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestJobManagerOneJobPerDeployment(t *testing.T) {
+	m := NewJobManager()
+	release := make(chan struct{})
+	first, err := m.Start("deploy", "dev", func(*Job) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if _, err := m.Start("destroy", "dev", func(*Job) error { return nil }); err == nil {
+		t.Error("second job on a busy deployment started")
+	}
+	other, err := m.Start("deploy", "prod", func(*Job) error { return nil })
+	if err != nil {
+		t.Errorf("job on another deployment: %v", err)
+	} else {
+		waitFor(t, other)
+	}
+
+	close(release)
+	waitFor(t, first)
+	// The deployment is released once its job finishes.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, err := m.Start("destroy", "dev", func(*Job) error { return nil })
+		if err == nil {
+			waitFor(t, j)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deployment still busy after its job finished: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobManagerGetAndList(t *testing.T) {
+	m := NewJobManager()
+	var ids []string
+	for _, d := range []string{"a", "b", "c"} {
+		j, err := m.Start("deploy", d, func(*Job) error { return nil })
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		waitFor(t, j)
+		ids = append(ids, j.id)
+	}
+
+	jobs := m.List()
+	if len(jobs) != len(ids) {
+		t.Fatalf("List returned %d jobs, want %d", len(jobs), len(ids))
+	}
+	for i, j := range jobs {
+		if j.id != ids[i] {
+			t.Errorf("List()[%d] = job %s, want %s", i, j.id, ids[i])
+		}
+	}
+
+	if j, ok := m.Get(ids[1]); !ok || j.deployment != "b" {
+		t.Errorf("Get(%s) = %v, %v", ids[1], j, ok)
+	}
+	if _, ok := m.Get("missing"); ok {
+		t.Error("Get of a missing job succeeded")
+	}
+}
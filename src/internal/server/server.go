@@ -0,0 +1,292 @@
+// Package server exposes deployment operations over a REST API.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/opentargets/platform-deployment-standalone/internal/config"
+	"github.com/opentargets/platform-deployment-standalone/internal/housekeeping"
+	"github.com/opentargets/platform-deployment-standalone/internal/tools"
+)
+
+// maxConfigSize is the maximum size of a config sent in a request body.
+const maxConfigSize = 64 * 1024
+
+// opsURIKey is the setting of the ops URI, which the server sets for every
+// deployment it creates so it can list and destroy them.
+const opsURIKey = "OT_OPS_URI"
+
+// Server serves the deployment REST API.
+type Server struct {
+	opsURI       string
+	defaultsPath string
+	// token is the bearer token requests must carry, or empty to accept any
+	// request, which is only allowed on a loopback address.
+	token string
+	jobs  *JobManager
+}
+
+// Deployment is the JSON representation of a cloud deployment.
+type Deployment struct {
+	Name   string `json:"name"`
+	Config string `json:"config"`
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	// Metadata is missing for deployments created before it was kept.
+	Metadata *housekeeping.Metadata `json:"metadata,omitempty"`
+}
+
+// New creates a Server that manages the deployments stored under opsURI. New
+// deployments are created from the config in defaultsPath, overridden by the
+// settings sent in the request. Requests must carry token as a bearer token,
+// unless it is empty.
+func New(opsURI, defaultsPath, token string) *Server {
+	return &Server{
+		opsURI:       opsURI,
+		defaultsPath: defaultsPath,
+		token:        token,
+		jobs:         NewJobManager(),
+	}
+}
+
+// Handler returns the HTTP handler for the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /deployments", s.listDeployments)
+	mux.HandleFunc("POST /deployments", s.createDeployment)
+	mux.HandleFunc("DELETE /deployments/{name}", s.destroyDeployment)
+	mux.HandleFunc("GET /jobs", s.listJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	return s.authenticate(mux)
+}
+
+// ListenAndServe serves the API on addr. Without a token, addr must be a
+// loopback address, so the API cannot be reached from other machines.
+func (s *Server) ListenAndServe(addr string) error {
+	if s.token == "" && !isLoopback(addr) {
+		return fmt.Errorf("refusing to serve on %s without a token, set one or listen on localhost", addr)
+	}
+	log.Printf("serving deployment api on %s for %s", addr, s.opsURI)
+	return http.ListenAndServe(addr, logRequests(s.Handler()))
+}
+
+// isLoopback returns true if addr listens on a loopback address only.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authenticate rejects the requests that do not carry the token of the server.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listDeployments(w http.ResponseWriter, _ *http.Request) {
+	r, err := housekeeping.ReadRegistry(s.opsURI)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("error listing deployments: %w", err))
+		return
+	}
+
+	entries := r.Entries()
+	deployments := make([]Deployment, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url, status := e.CheckInstance()
+			deployments[i] = Deployment{
+				Name:     e.Name,
+				Config:   e.Config,
+				URL:      url,
+				Status:   status,
+				Metadata: e.Metadata,
+			}
+		}()
+	}
+	wg.Wait()
+
+	writeJSON(w, http.StatusOK, deployments)
+}
+
+// createDeployment takes a dotenv config in the request body and deploys it.
+func (s *Server) createDeployment(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("config is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+
+	// The overrides are set in the defaults file, so keys that are not settings
+	// are kept in the uploaded config.
+	f, err := tools.LoadEnvFile(s.defaultsPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("error loading defaults: %w", err))
+		return
+	}
+	overrides, err := tools.ParseEnvFile(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error parsing config: %w", err))
+		return
+	}
+	// Deployments are kept in the ops URI of the server, which lists and
+	// destroys them there.
+	if value, _ := overrides.Get(opsURIKey); value != "" && value != s.opsURI {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s cannot be set, deployments are kept in %s", opsURIKey, s.opsURI))
+		return
+	}
+	for _, key := range overrides.Keys() {
+		value, _ := overrides.Get(key)
+		f.Set(key, value)
+	}
+	f.Set(opsURIKey, s.opsURI)
+
+	c, err := config.NewCloudDeploymentConfigFromFile(f)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := c.Validate(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("bad configuration: %w", err))
+		return
+	}
+	existing, err := housekeeping.FindExisting(c)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if existing.Exists() {
+		writeError(w, http.StatusConflict, fmt.Errorf("deployment %s already exists", c.SubdomainName.Value))
+		return
+	}
+	if err := c.Preflight(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("preflight checks failed: %w", err))
+		return
+	}
+
+	j, err := s.jobs.Start("deploy", c.SubdomainName.Value, func(j *Job) error {
+		j.Logf("deploying %s", c.SubdomainName.Value)
+		unlock, err := housekeeping.Lock(c, "deploy")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if err := housekeeping.PrepareDeploymentDir(c); err != nil {
+			return err
+		}
+		if err := housekeeping.WriteConfig(c); err != nil {
+			return err
+		}
+		outputs, err := housekeeping.DeployCloud(c, j)
+		if err != nil {
+			return err
+		}
+		if err := housekeeping.UploadConfig(c, outputs, 0); err != nil {
+			return fmt.Errorf("error uploading configuration file to ops uri: %w", err)
+		}
+		j.Logf("deployment available at %s", outputs["instance_url"])
+		if record := outputs["dns_record"]; record != "" {
+			j.Logf("create this record in your dns: %s", record)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, j.View(false))
+}
+
+func (s *Server) destroyDeployment(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := config.ValidateSubdomainName(name); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid deployment name: %w", err))
+		return
+	}
+	configURI := fmt.Sprintf("%s/%s", s.opsURI, name)
+
+	j, err := s.jobs.Start("destroy", name, func(j *Job) error {
+		j.Logf("destroying %s", name)
+		if err := housekeeping.DestroyCloud(configURI, j); err != nil {
+			return err
+		}
+		j.Logf("deployment %s destroyed", name)
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, j.View(false))
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, _ *http.Request) {
+	jobs := s.jobs.List()
+	views := make([]JobView, len(jobs))
+	for i, j := range jobs {
+		views[i] = j.View(false)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, j.View(true))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testOpsURI = "gs://test-ops/devinstance"
+
+// newTestServer returns a server with a minimal defaults file, whose handlers
+// are tested up to the point they would reach Google Cloud.
+func newTestServer(t *testing.T, token string) *Server {
+	t.Helper()
+	defaults := filepath.Join(t.TempDir(), "defaults-cloud")
+	content := "OT_DEPLOYMENT_TYPE=\"cloud\"\nOT_OPS_URI=\"gs://other-ops/devinstance\"\n"
+	if err := os.WriteFile(defaults, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return New(testOpsURI, defaults, token)
+}
+
+// do sends a request to the handler of s, and returns the response code and
+// its error message, if any.
+func do(t *testing.T, s *Server, method, path, body string, header map[string]string) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+
+	var res struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res.Error
+}
+
+func TestAuthentication(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing token", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "Bearer nope", http.StatusUnauthorized},
+		{"wrong scheme", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+		{"valid token", "s3cret", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.token)
+			code, _ := do(t, s, http.MethodGet, "/jobs", "", map[string]string{"Authorization": tt.header})
+			if code != tt.want {
+				t.Errorf("GET /jobs = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"localhost:8000": true,
+		"127.0.0.1:8000": true,
+		"[::1]:8000":     true,
+		":8000":          false,
+		"0.0.0.0:8000":   false,
+		"10.0.0.2:8000":  false,
+		"localhost":      false,
+	}
+	for addr, want := range tests {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCreateDeploymentRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		want      int
+		wantError string
+	}{
+		{"too large", "OT_API_TAG=\"" + strings.Repeat("x", maxConfigSize) + "\"\n", http.StatusRequestEntityTooLarge, "larger than"},
+		{"not dotenv", "not a config\n", http.StatusBadRequest, "error parsing config"},
+		{"other ops uri", "OT_OPS_URI=\"gs://elsewhere/devinstance\"\n", http.StatusBadRequest, "OT_OPS_URI cannot be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "")
+			code, msg := do(t, s, http.MethodPost, "/deployments", tt.body, nil)
+			if code != tt.want {
+				t.Errorf("POST /deployments = %d (%s), want %d", code, msg, tt.want)
+			}
+			if !strings.Contains(msg, tt.wantError) {
+				t.Errorf("error = %q, want it to contain %q", msg, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestDestroyDeploymentRejectsBadNames(t *testing.T) {
+	s := newTestServer(t, "")
+	code, _ := do(t, s, http.MethodDelete, "/deployments/Not_Valid", "", nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("DELETE /deployments/Not_Valid = %d, want %d", code, http.StatusBadRequest)
+	}
+	if jobs := s.jobs.List(); len(jobs) != 0 {
+		t.Errorf("%d jobs started for an invalid name", len(jobs))
+	}
+}
+
+func TestJobEndpoints(t *testing.T) {
+	s := newTestServer(t, "")
+	j, err := s.jobs.Start("deploy", "dev", func(j *Job) error {
+		j.Logf("done")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, j)
+
+	r := httptest.NewRequest(http.MethodGet, "/jobs/"+j.id, nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /jobs/%s = %d", j.id, w.Code)
+	}
+	var v JobView
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != JobSucceeded || v.Logs != "done\n" {
+		t.Errorf("job = %+v, want succeeded with its logs", v)
+	}
+
+	if code, _ := do(t, s, http.MethodGet, "/jobs/missing", "", nil); code != http.StatusNotFound {
+		t.Errorf("GET /jobs/missing = %d, want %d", code, http.StatusNotFound)
+	}
+}
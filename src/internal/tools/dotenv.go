@@ -0,0 +1,255 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envKey matches the keys of a dotenv file.
+var envKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// envEscaper escapes a value for a double-quoted dotenv value, which is also
+// read by bash when the VM sources its config. Newlines are kept as they are,
+// as both read them inside double quotes.
+var envEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+
+// EnvFile is a dotenv file that keeps its comments, blank lines, unknown keys
+// and ordering, so it can be read, changed and written back without losing any
+// of them. Only the assignments that are set are written anew.
+type EnvFile struct {
+	entries []*envEntry
+	// trailingNewline is true if the file ended with a newline.
+	trailingNewline bool
+}
+
+// envEntry is an assignment, or a comment or blank line if key is empty.
+type envEntry struct {
+	key   string
+	value string
+	// raw is the entry as read, which may span several lines.
+	raw string
+	// comment is the comment after the value, with the space before it.
+	comment string
+	// export is true if the assignment is prefixed with export.
+	export bool
+	// changed is true if the value was set, so it is written anew.
+	changed bool
+}
+
+// QuoteEnvValue returns a value double-quoted and escaped for a dotenv file.
+func QuoteEnvValue(v string) string {
+	return `"` + envEscaper.Replace(v) + `"`
+}
+
+// ParseEnvFile parses the contents of a dotenv file.
+func ParseEnvFile(content string) (*EnvFile, error) {
+	f := &EnvFile{trailingNewline: content == "" || strings.HasSuffix(content, "\n")}
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if content == "" {
+		lines = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		start := i
+		// raw keeps the carriage returns of CRLF files, so they are written
+		// back as they were.
+		raw := lines[i]
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			f.entries = append(f.entries, &envEntry{raw: raw})
+			continue
+		}
+
+		assignment, export := strings.CutPrefix(trimmed, "export ")
+		key, rest, ok := strings.Cut(assignment, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKey.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=value, found %q", start+1, trimmed)
+		}
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value, after string
+		switch {
+		case strings.HasPrefix(rest, `"`), strings.HasPrefix(rest, `'`):
+			// Quoted values may span several lines, up to the closing quote.
+			quote := rest[0]
+			for {
+				var closed bool
+				value, after, closed = unquote(rest, quote)
+				if closed {
+					break
+				}
+				if i+1 >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated quoted value for %s", start+1, key)
+				}
+				i++
+				rest += "\n" + strings.TrimSuffix(lines[i], "\r")
+				raw += "\n" + lines[i]
+			}
+		default:
+			// Unquoted values end where a comment starts.
+			value, after = rest, ""
+			if j := strings.Index(rest, " #"); j >= 0 {
+				value, after = rest[:j], rest[j:]
+			}
+			value = strings.TrimSpace(value)
+		}
+
+		comment := ""
+		if t := strings.TrimSpace(after); t != "" {
+			if !strings.HasPrefix(t, "#") {
+				return nil, fmt.Errorf("line %d: unexpected %q after the value of %s", start+1, t, key)
+			}
+			comment = after[:len(after)-len(strings.TrimLeft(after, " \t"))] + t
+		}
+
+		f.entries = append(f.entries, &envEntry{key: key, value: value, raw: raw, comment: comment, export: export})
+	}
+
+	return f, nil
+}
+
+// unquote reads a value in quote from the start of s, and returns it with what
+// follows the closing quote. Double-quoted values are unescaped. closed is false
+// if s ends before the closing quote.
+func unquote(s string, quote byte) (value, after string, closed bool) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return sb.String(), s[i+1:], true
+		case c == '\\' && quote == '"' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case '"', '\\', '$', '`':
+				sb.WriteByte(s[i])
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(s[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", "", false
+}
+
+// Get returns the value of a key, and whether it is set. The last assignment of
+// a key wins.
+func (f *EnvFile) Get(key string) (string, bool) {
+	value, found := "", false
+	for _, e := range f.entries {
+		if e.key == key {
+			value, found = e.value, true
+		}
+	}
+	return value, found
+}
+
+// Set sets the value of a key where it is assigned, or appends it at the end of
+// the file if it is not. Values that do not change are left as they were
+// written.
+func (f *EnvFile) Set(key, value string) {
+	found := false
+	for _, e := range f.entries {
+		if e.key == key {
+			found = true
+			if e.value != value {
+				e.value = value
+				e.changed = true
+			}
+		}
+	}
+	if !found {
+		f.entries = append(f.entries, &envEntry{key: key, value: value, changed: true})
+	}
+}
+
+// Keys returns the keys of the file in the order they are first assigned.
+func (f *EnvFile) Keys() []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, e := range f.entries {
+		if e.key != "" && !seen[e.key] {
+			keys = append(keys, e.key)
+			seen[e.key] = true
+		}
+	}
+	return keys
+}
+
+// Map returns the values of the file keyed by name.
+func (f *EnvFile) Map() map[string]string {
+	env := map[string]string{}
+	for _, e := range f.entries {
+		if e.key != "" {
+			env[e.key] = e.value
+		}
+	}
+	return env
+}
+
+// Clone returns a copy of the file that can be changed independently.
+func (f *EnvFile) Clone() *EnvFile {
+	clone := &EnvFile{trailingNewline: f.trailingNewline}
+	for _, e := range f.entries {
+		entry := *e
+		clone.entries = append(clone.entries, &entry)
+	}
+	return clone
+}
+
+// String returns the contents of the file, with the values that were set
+// written anew and everything else as it was read.
+func (f *EnvFile) String() string {
+	var sb strings.Builder
+	for i, e := range f.entries {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		if e.changed {
+			if e.export {
+				sb.WriteString("export ")
+			}
+			sb.WriteString(e.key + "=" + QuoteEnvValue(e.value) + e.comment)
+			if strings.HasSuffix(e.raw, "\r") {
+				sb.WriteString("\r")
+			}
+		} else {
+			sb.WriteString(e.raw)
+		}
+	}
+	if f.trailingNewline && len(f.entries) > 0 {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// LoadEnvFile reads a dotenv file from a local path or a GCS URI.
+func LoadEnvFile(configFilePath string) (*EnvFile, error) {
+	var content string
+	if strings.HasPrefix(configFilePath, "gs://") {
+		c, err := ReadFileFromGCS(configFilePath)
+		if err != nil {
+			return nil, err
+		}
+		content = c
+	} else {
+		c, err := os.ReadFile(configFilePath)
+		if err != nil {
+			return nil, err
+		}
+		content = string(c)
+	}
+
+	f, err := ParseEnvFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", configFilePath, err)
+	}
+	return f, nil
+}
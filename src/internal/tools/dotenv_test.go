@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"unquoted", "A=1\nB= two \n", map[string]string{"A": "1", "B": "two"}},
+		{"comments", "# header\n\nA=1 # one\nB=x#y\n", map[string]string{"A": "1", "B": "x#y"}},
+		{"no trailing newline", "A=1", map[string]string{"A": "1"}},
+		{"export", "export A=1\nexport B=\"two\" # comment\n", map[string]string{"A": "1", "B": "two"}},
+		{"hash in double quotes", "A=\"a # b\" # comment\n", map[string]string{"A": "a # b"}},
+		{"hash in single quotes", "A='#not a comment'\n", map[string]string{"A": "#not a comment"}},
+		{"escapes", `A="a \"b\" \\ \$c \` + "`" + `d\` + "`" + `"` + "\n", map[string]string{"A": "a \"b\" \\ $c `d`"}},
+		{"single quotes are literal", `A='a \n $b'` + "\n", map[string]string{"A": `a \n $b`}},
+		{"multiline", "A=\"one\ntwo\"\nB=3\n", map[string]string{"A": "one\ntwo", "B": "3"}},
+		{"crlf", "# c\r\nA=1\r\nB=\"two\" # d\r\nC=\"x\r\ny\"\r\n", map[string]string{"A": "1", "B": "two", "C": "x\ny"}},
+		{"last assignment wins", "A=1\nA=2\n", map[string]string{"A": "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseEnvFile(tt.content)
+			if err != nil {
+				t.Fatalf("ParseEnvFile() error = %v", err)
+			}
+			got := f.Map()
+			if len(got) != len(tt.want) {
+				t.Errorf("ParseEnvFile() = %q, want %q", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("ParseEnvFile()[%s] = %q, want %q", k, got[k], v)
+				}
+			}
+			if s := f.String(); s != tt.content {
+				t.Errorf("String() = %q, want the content as read %q", s, tt.content)
+			}
+		})
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"no assignment", "A\n"},
+		{"bad key", "1A=1\n"},
+		{"unterminated quote", "A=\"one\nB=2\n"},
+		{"text after quotes", "A=\"one\" two\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseEnvFile(tt.content); err == nil {
+				t.Errorf("ParseEnvFile(%q) error = nil, want an error", tt.content)
+			}
+		})
+	}
+}
+
+func TestEnvFileSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		key     string
+		value   string
+		want    string
+	}{
+		{"keeps comments", "# header\nA=1 # one\nB=2\n", "A", "3", "# header\nA=\"3\" # one\nB=2\n"},
+		{"unchanged value", "A=1 # one\n", "A", "1", "A=1 # one\n"},
+		{"appends", "A=1\n", "B", "2", "A=1\nB=\"2\"\n"},
+		{"keeps export", "export A=1\n", "A", "2", "export A=\"2\"\n"},
+		{"keeps crlf", "A=1\r\nB=2\r\n", "A", "3", "A=\"3\"\r\nB=2\r\n"},
+		{"quotes", "A=1\n", "A", "a \"b\" $c", "A=\"a \\\"b\\\" \\$c\"\n"},
+		{"hash", "A=1\n", "A", "a # b", "A=\"a # b\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseEnvFile(tt.content)
+			if err != nil {
+				t.Fatalf("ParseEnvFile() error = %v", err)
+			}
+			f.Set(tt.key, tt.value)
+			got := f.String()
+			if got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			again, err := ParseEnvFile(got)
+			if err != nil {
+				t.Fatalf("ParseEnvFile() of the written file error = %v", err)
+			}
+			if v, _ := again.Get(tt.key); v != tt.value {
+				t.Errorf("Get(%s) after writing = %q, want %q", tt.key, v, tt.value)
+			}
+		})
+	}
+}
+
+// TestQuoteEnvValueBash checks that quoted values read the same when the VM
+// sources the config with bash.
+func TestQuoteEnvValueBash(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	values := []string{
+		"",
+		"plain",
+		"with spaces",
+		"a # b",
+		`double "quotes"`,
+		"single 'quotes'",
+		`back\slash \n`,
+		"$HOME ${HOME} $(echo no)",
+		"`echo no`",
+		"!history",
+		"multiple\nlines",
+		"trailing backslash\\",
+	}
+	for _, v := range values {
+		path := filepath.Join(t.TempDir(), "config")
+		if err := os.WriteFile(path, []byte("X="+QuoteEnvValue(v)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(bash, "-c", `source "$1" && printf %s "$X"`, "bash", path).Output()
+		if err != nil {
+			t.Fatalf("sourcing %q: %v", v, err)
+		}
+		if string(out) != v {
+			t.Errorf("bash read QuoteEnvValue(%q) as %q", v, out)
+		}
+
+		f, err := ParseEnvFile("X=" + QuoteEnvValue(v) + "\n")
+		if err != nil {
+			t.Fatalf("ParseEnvFile() of %q error = %v", v, err)
+		}
+		if got, _ := f.Get("X"); got != v {
+			t.Errorf("ParseEnvFile() read QuoteEnvValue(%q) as %q", v, got)
+		}
+	}
+}
@@ -2,27 +2,46 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"net/http"
 	"strings"
 
 	"cloud.google.com/go/storage"
 	"github.com/charmbracelet/huh/spinner"
-	"github.com/joho/godotenv"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 )
 
-// RandomString generates a random string of the specified length using the characters 'a' to 'h'.
-func RandomString(length int) string {
-	letters := []rune("abcdefgh")
-	randomString := make([]rune, length)
-
-	for i := range randomString {
-		randomString[i] = letters[rand.Intn(len(letters))]
+// nameAdjectives and nameAnimals make up the names generated by RandomName.
+// Words are kept short so names fit in a subdomain of 16 characters.
+var (
+	nameAdjectives = []string{
+		"amber", "bold", "brave", "brisk", "calm", "clever", "cosy", "crisp", "daring", "eager",
+		"fair", "fancy", "fond", "gentle", "glad", "golden", "grand", "happy", "hardy", "honest",
+		"jolly", "keen", "kind", "lively", "lucky", "merry", "mighty", "modest", "nimble", "noble",
+		"polite", "proud", "quick", "quiet", "rapid", "ready", "shiny", "silent", "smart", "snowy",
+		"steady", "sunny", "swift", "tidy", "vivid", "warm", "wise", "witty", "young", "zesty",
+	}
+	nameAnimals = []string{
+		"badger", "beaver", "bison", "camel", "cobra", "crane", "dingo", "eagle", "falcon", "ferret",
+		"finch", "gecko", "heron", "hyena", "ibex", "iguana", "jackal", "koala", "lemur", "llama",
+		"lynx", "magpie", "marten", "moose", "newt", "ocelot", "okapi", "orca", "otter", "panda",
+		"puffin", "quail", "rabbit", "raven", "robin", "salmon", "seal", "shrew", "sloth", "stoat",
+		"swan", "tapir", "tiger", "toucan", "turtle", "viper", "walrus", "wombat", "yak", "zebra",
 	}
+)
 
-	return string(randomString)
+// RandomName generates a memorable random name such as `brisk-otter-42`, from
+// 250000 combinations.
+func RandomName() string {
+	return fmt.Sprintf("%s-%s-%02d",
+		nameAdjectives[rand.Intn(len(nameAdjectives))],
+		nameAnimals[rand.Intn(len(nameAnimals))],
+		rand.Intn(100),
+	)
 }
 
 // Either returns the first non-empty string from either or.
@@ -125,29 +144,171 @@ func WriteFileToGCS(uri string, content string) error {
 	defer client.Close()
 
 	writer := client.Bucket(bucketName).Object(blobName).NewWriter(ctx)
-	defer writer.Close()
+	if _, err := writer.Write([]byte(content)); err != nil {
+		writer.Close()
+		return err
+	}
+	// The upload is only done, and its errors known, once the writer is closed.
+	return writer.Close()
+}
+
+// ReadFileFromGCSWithGeneration reads a file from Google Cloud Storage and
+// returns its content along with the generation read, to update it with
+// WriteFileToGCSIfGeneration.
+func ReadFileFromGCSWithGeneration(uri string) (string, int64, error) {
+	ctx := context.Background()
+
+	parts := strings.SplitN(strings.TrimPrefix(uri, "gs://"), "/", 2)
+	if len(parts) < 2 {
+		return "", 0, fmt.Errorf("invalid gcs uri: %s", uri)
+	}
+
+	bucketName := parts[0]
+	blobName := parts[1]
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	defer client.Close()
+
+	reader, err := client.Bucket(bucketName).Object(blobName).NewReader(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	defer reader.Close()
 
-	_, err = writer.Write([]byte(content))
+	content, err := io.ReadAll(reader)
+	return string(content), reader.Attrs.Generation, err
+}
+
+// WriteFileToGCSIfGeneration writes a string to a file in Google Cloud Storage
+// only if the file is still at generation, or does not exist if generation is
+// 0. Otherwise the write fails, and IsPreconditionFailed reports it.
+func WriteFileToGCSIfGeneration(uri string, content string, generation int64) error {
+	_, err := WriteFileToGCSAtGeneration(uri, content, generation)
 	return err
+}
+
+// WriteFileToGCSAtGeneration is WriteFileToGCSIfGeneration, returning the
+// generation written so the file can be updated or deleted again only if no one
+// else changed it.
+func WriteFileToGCSAtGeneration(uri string, content string, generation int64) (int64, error) {
+	ctx := context.Background()
+
+	parts := strings.SplitN(strings.TrimPrefix(uri, "gs://"), "/", 2)
+	if len(parts) < 2 {
+		return 0, fmt.Errorf("invalid gcs uri: %s", uri)
+	}
+
+	bucketName := parts[0]
+	blobName := parts[1]
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	conditions := storage.Conditions{DoesNotExist: true}
+	if generation != 0 {
+		conditions = storage.Conditions{GenerationMatch: generation}
+	}
+
+	writer := client.Bucket(bucketName).Object(blobName).If(conditions).NewWriter(ctx)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		writer.Close()
+		return 0, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	return writer.Attrs().Generation, nil
+}
 
+// IsPreconditionFailed returns true if a Google Cloud Storage write failed
+// because the file changed since it was read.
+func IsPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
+// DeleteFileFromGCS deletes a file from Google Cloud Storage.
+func DeleteFileFromGCS(uri string) error {
+	ctx := context.Background()
+
+	parts := strings.SplitN(strings.TrimPrefix(uri, "gs://"), "/", 2)
+	if len(parts) < 2 {
+		return fmt.Errorf("invalid gcs uri: %s", uri)
+	}
+
+	bucketName := parts[0]
+	blobName := parts[1]
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Bucket(bucketName).Object(blobName).Delete(ctx)
+}
+
+// DeleteFileFromGCSIfGeneration deletes a file from Google Cloud Storage only
+// if it is still at generation.
+func DeleteFileFromGCSIfGeneration(uri string, generation int64) error {
+	ctx := context.Background()
+
+	parts := strings.SplitN(strings.TrimPrefix(uri, "gs://"), "/", 2)
+	if len(parts) < 2 {
+		return fmt.Errorf("invalid gcs uri: %s", uri)
+	}
+
+	bucketName := parts[0]
+	blobName := parts[1]
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Bucket(bucketName).Object(blobName).If(storage.Conditions{GenerationMatch: generation}).Delete(ctx)
 }
 
 // LoadEnvFromFile reads environment variables from a specified file and returns them as a map.
 func LoadEnvFromFile(configFilePath string) (map[string]string, error) {
-	if strings.HasPrefix(configFilePath, "gs://") {
-		config, err := ReadFileFromGCS(configFilePath)
-		if err != nil {
-			return nil, err
-		}
+	f, err := LoadEnvFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return f.Map(), nil
+}
 
-		return godotenv.Unmarshal(config)
+// ParseEnv parses the contents of a dotenv file and returns them as a map.
+func ParseEnv(content string) (map[string]string, error) {
+	f, err := ParseEnvFile(content)
+	if err != nil {
+		return nil, err
 	}
+	return f.Map(), nil
+}
+
+// spinnersDisabled makes RunWithSpinner run actions without rendering a spinner.
+var spinnersDisabled bool
 
-	return godotenv.Read(configFilePath)
+// DisableSpinners turns off spinners for the rest of the process, for use when
+// there is no terminal to draw them on (e.g. when running as a server).
+func DisableSpinners() {
+	spinnersDisabled = true
 }
 
 // RunWithSpinner runs a function with a spinner and a title.
 func RunWithSpinner(title string, action func()) error {
+	if spinnersDisabled {
+		action()
+		return nil
+	}
 	spinnerType := spinner.Points
 	return spinner.New().Type(spinnerType).Title(" " + title).Action(action).Run()
 }